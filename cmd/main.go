@@ -2,37 +2,93 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 	"text/template"
-	"time"
 
 	"github.com/prometheus/client_golang/prometheus/push"
 	log "github.com/sirupsen/logrus"
+	"github.com/uswitch/vault-creds/pkg/config"
 	"github.com/uswitch/vault-creds/pkg/kube"
 	"github.com/uswitch/vault-creds/pkg/metrics"
 	"github.com/uswitch/vault-creds/pkg/vault"
+	"golang.org/x/crypto/ssh/terminal"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
 
 var (
-	vaultAddr           = kingpin.Flag("vault-addr", "Vault address, e.g. https://vault:8200").String()
+	vaultAddr           = kingpin.Flag("vault-addr", "Vault address, e.g. https://vault:8200. Repeat to fail over between endpoints of the same cluster").Strings()
 	serviceAccountToken = kingpin.Flag("token-file", "Service account token path").Default("/var/run/secrets/kubernetes.io/serviceaccount/token").String()
-	loginPath           = kingpin.Flag("login-path", "Vault path to authenticate against").Required().String()
-	authRole            = kingpin.Flag("auth-role", "Kubernetes authentication role").Required().String()
-	secretPath          = kingpin.Flag("secret-path", "Path to secret in Vault. eg. database/creds/foo").Required().String()
+	tokenAudience       = kingpin.Flag("token-audience", "Audience to request a service account token for with the TokenRequest API, rather than reading --token-file").String()
+	tokenExpiry         = kingpin.Flag("token-expiry", "Expiry of service account tokens requested with the TokenRequest API").Default("10m").Duration()
+	serviceAccount      = kingpin.Flag("service-account", "Name of the service account to request tokens for, defaults to the owner of --token-file").Envar("SERVICE_ACCOUNT").String()
+	authMethod          = kingpin.Flag("auth-method", "Method used to authenticate with Vault: kubernetes, approle, jwt, cert, aws, gcp, azure, agent or token").Default("kubernetes").Enum("kubernetes", "approle", "jwt", "cert", "aws", "gcp", "azure", "agent", "token")
+	loginPath           = kingpin.Flag("login-path", "Vault path to authenticate against").String()
+	authRole            = kingpin.Flag("auth-role", "Role to authenticate as").String()
+	secretPath          = kingpin.Flag("secret-path", "Path to secret in Vault. eg. database/creds/foo").String()
+	secretType          = kingpin.Flag("secret-type", "Type of secret at the secret path: credential, dynamic or kv").Default("credential").Enum("credential", "dynamic", "kv")
+	secretVersion       = kingpin.Flag("secret-version", "Version of a kv v2 secret to read, defaults to the latest").Int()
 	caCert              = kingpin.Flag("ca-cert", "Path to CA certificate/certificate folder to validate Vault server").String()
+	clientCert          = kingpin.Flag("client-cert", "Path to a client certificate presented to Vault").String()
+	clientKey           = kingpin.Flag("client-key", "Path to the private key of --client-cert").String()
+	tlsServerName       = kingpin.Flag("tls-server-name", "Name used to verify the Vault server certificate, defaults to the host of --vault-addr").String()
+	tlsMinVersion       = kingpin.Flag("tls-min-version", "Minimum TLS version used to connect to Vault: tls10, tls11, tls12 or tls13").Default("tls12").Enum("tls10", "tls11", "tls12", "tls13")
+	vaultNamespace      = kingpin.Flag("vault-namespace", "Vault Enterprise namespace to read secrets from").Envar("VAULT_NAMESPACE").String()
+	authNamespace       = kingpin.Flag("auth-namespace", "Vault Enterprise namespace to log in to, defaults to --vault-namespace").String()
+
+	roleID          = kingpin.Flag("role-id", "AppRole role ID").Envar("VAULT_ROLE_ID").String()
+	roleIDFile      = kingpin.Flag("role-id-file", "Path to a file containing the AppRole role ID").String()
+	secretID        = kingpin.Flag("secret-id", "AppRole secret ID").Envar("VAULT_SECRET_ID").String()
+	secretIDFile    = kingpin.Flag("secret-id-file", "Path to a file containing the AppRole secret ID").String()
+	secretIDWrapped = kingpin.Flag("secret-id-wrapped", "Whether the AppRole secret ID is a response wrapping token").Default("false").Bool()
+
+	jwt     = kingpin.Flag("jwt", "JWT to authenticate with").Envar("VAULT_JWT").String()
+	jwtFile = kingpin.Flag("jwt-file", "Path to a file containing the JWT to authenticate with, read on every login").String()
+
+	awsRegion   = kingpin.Flag("aws-region", "Region of the STS endpoint used for aws authentication, defaults to the global endpoint").String()
+	awsServerID = kingpin.Flag("aws-server-id", "Value of the X-Vault-AWS-IAM-Server-ID header required by the aws auth method").String()
+
+	metadataAddr      = kingpin.Flag("metadata-addr", "Address of the instance metadata server used for gcp and azure authentication").String()
+	gcpServiceAccount = kingpin.Flag("gcp-service-account", "GCE service account to request an identity token for").Default("default").String()
+	azureResource     = kingpin.Flag("azure-resource", "Resource to request a managed identity token for").Default("https://management.azure.com/").String()
+	azureClientID     = kingpin.Flag("azure-client-id", "Client ID of a user assigned managed identity").String()
+
+	agentSink = kingpin.Flag("agent-sink", "Path to the Vault Agent auto-auth file sink to read the token from").String()
+
+	vaultToken     = kingpin.Flag("vault-token", "Vault token to use for token authentication").Envar("VAULT_TOKEN").String()
+	vaultTokenFile = kingpin.Flag("vault-token-file", "Path to a file containing the Vault token, defaults to ~/.vault-token").String()
+	username       = kingpin.Flag("username", "Username to log in with, the password is prompted for on the terminal").String()
+
+	configFile = kingpin.Flag("config", "Path to a YAML file listing the secrets to manage").ExistingFile()
+	tokenPath  = kingpin.Flag("token-path", "Path the Vault token is saved to, defaults to the first lease path with a .token suffix").String()
 
 	templateFile = kingpin.Flag("template", "Path to template file").ExistingFile()
 	out          = kingpin.Flag("out", "Output file name").String()
 
-	renewInterval = kingpin.Flag("renew-interval", "Interval to renew credentials").Default("15m").Duration()
+	renewInterval = kingpin.Flag("renew-interval", "Longest interval between renewals of credentials").Default("15m").Duration()
+	renewFraction = kingpin.Flag("renew-fraction", "Fraction of the remaining TTL, or of a certificate's lifetime, to wait before renewing").Default("0.67").Float64()
+	renewJitter   = kingpin.Flag("renew-jitter", "Random jitter applied to renewals as a fraction of the wait").Default("0.1").Float64()
 	leaseDuration = kingpin.Flag("lease-duration", "Credentials lease duration").Default("1h").Duration()
+	tokenDuration = kingpin.Flag("token-duration", "Increment requested when renewing the Vault token").Default("1h").Duration()
+	tokenRenew    = kingpin.Flag("token-renew-interval", "Longest interval between renewals of the Vault token").Default("15m").Duration()
+	revokeGrace   = kingpin.Flag("revoke-grace", "Time to wait after rotating credentials before revoking the old lease").Default("5m").Duration()
 
 	getCertificate = kingpin.Flag("get-certificate", "Whether to fetch certificates or not").Default("false").Bool()
 	commonName     = kingpin.Flag("common-name", "Common name used for certificates").String()
 	ttl            = kingpin.Flag("ttl", "TTL for certificate").String()
+	keyType        = kingpin.Flag("key-type", "Generate the certificate key locally (rsa, ec or ed25519) and have Vault sign a CSR, requires a <mount>/sign/<role> --secret-path").Enum("rsa", "ec", "ed25519")
+	keyBits        = kingpin.Flag("key-bits", "Size of the generated RSA key or EC curve").Int()
+	certOut        = kingpin.Flag("cert-out", "Path to write the PEM certificate to").String()
+	keyOut         = kingpin.Flag("key-out", "Path to write the PEM private key to").String()
+	caOut          = kingpin.Flag("ca-out", "Path to write the PEM issuing CA to").String()
+	fullchainOut   = kingpin.Flag("fullchain-out", "Path to write the PEM certificate followed by its CA chain to").String()
+	keystoreOut    = kingpin.Flag("keystore-out", "Path to write a keystore holding the certificate and private key to").String()
+	truststoreOut  = kingpin.Flag("truststore-out", "Path to write a truststore holding the CA chain to").String()
+	keystoreType   = kingpin.Flag("keystore-type", "Format of --keystore-out and --truststore-out, pkcs12 or jks").Default("pkcs12").Enum("pkcs12", "jks")
+	keystorePass   = kingpin.Flag("keystore-password-file", "File containing the keystore password, one is generated if it doesn't exist. Defaults to the keystore path with a .password suffix").String()
 
 	jsonOutput = kingpin.Flag("json-log", "Output log in JSON format").Default("false").Bool()
 
@@ -44,35 +100,279 @@ var (
 )
 
 var (
-	namespace  = os.Getenv("NAMESPACE")
-	podName    = os.Getenv("POD_NAME")
-	leaseExist = false
+	namespace = os.Getenv("NAMESPACE")
+	podName   = os.Getenv("POD_NAME")
 )
 
 var (
 	SHA = ""
 )
 
-//This removes the lease and token files in the event of them being expired
-func cleanUp(leasePath, tokenPath string, pusher *push.Pusher) {
+// This removes the lease, token and unwrapped secret id files in the event of them being expired
+func cleanUp(leasePaths []string, tokenPath string, pusher *push.Pusher) {
 	log.Infof("deleting lease and credentials")
 
-	err := os.Remove(leasePath)
-	if err != nil {
-		log.Errorf("failed to remove lease: %s", err)
+	for _, leasePath := range leasePaths {
+		err := os.Remove(leasePath)
+		if err != nil {
+			log.Errorf("failed to remove lease: %s", err)
+		}
 	}
 
-	err = os.Remove(tokenPath)
-	if err != nil {
-		log.Errorf("failed to remove token: %s", err)
+	if tokenPath != "" {
+		err := os.Remove(tokenPath)
+		if err != nil && !os.IsNotExist(err) {
+			log.Errorf("failed to remove token: %s", err)
+		}
+
+		err = os.Remove(tokenPath + ".secret-id")
+		if err != nil && !os.IsNotExist(err) {
+			log.Errorf("failed to remove unwrapped secret id: %s", err)
+		}
 	}
 
-	err = pusher.Delete()
+	err := pusher.Delete()
 	if err != nil {
 		log.Errorf("failed to delete pusher: %s", err)
 	}
 }
 
+func loadConfig() (*config.Config, error) {
+	var cfg *config.Config
+	var err error
+
+	if *configFile != "" {
+		defaults := &config.Secret{
+			Type:          vault.SecretType(*secretType),
+			Template:      *templateFile,
+			LeaseDuration: *leaseDuration,
+			RenewInterval: *renewInterval,
+			RenewFraction: *renewFraction,
+			RevokeGrace:   *revokeGrace,
+		}
+		cfg, err = config.Load(*configFile, defaults)
+	} else {
+		if *secretPath == "" {
+			return nil, fmt.Errorf("either --config or --secret-path must be supplied")
+		}
+
+		secret := &config.Secret{
+			Path:          *secretPath,
+			Type:          vault.SecretType(*secretType),
+			Template:      *templateFile,
+			Out:           *out,
+			Version:       *secretVersion,
+			LeaseDuration: *leaseDuration,
+			RenewInterval: *renewInterval,
+			RenewFraction: *renewFraction,
+			RevokeGrace:   *revokeGrace,
+		}
+		if *getCertificate {
+			secret.Type = vault.CertificateType
+			secret.CommonName = *commonName
+			secret.TTL = *ttl
+			secret.KeyType = *keyType
+			secret.KeyBits = *keyBits
+			secret.Files = certificateFiles(map[string]string{
+				vault.CertificateContents: *certOut,
+				vault.PrivateKeyContents:  *keyOut,
+				vault.CAContents:          *caOut,
+				vault.FullChainContents:   *fullchainOut,
+			})
+			secret.Keystores = keystores(map[string]string{
+				vault.KeystoreContents:   *keystoreOut,
+				vault.TruststoreContents: *truststoreOut,
+			})
+		}
+		cfg, err = config.FromSecret(secret, *tokenPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if *tokenPath != "" {
+		cfg.TokenPath = *tokenPath
+	}
+
+	return cfg, nil
+}
+
+// certificateFiles returns a file for each part of the
+// certificate given a path
+func certificateFiles(paths map[string]string) []*vault.CertificateFile {
+	files := make([]*vault.CertificateFile, 0, len(paths))
+	for _, contents := range []string{vault.CertificateContents, vault.PrivateKeyContents, vault.CAContents, vault.FullChainContents} {
+		if paths[contents] != "" {
+			files = append(files, &vault.CertificateFile{Path: paths[contents], Contents: contents})
+		}
+	}
+	return files
+}
+
+// keystores returns a keystore of --keystore-type for each path given
+func keystores(paths map[string]string) []*vault.Keystore {
+	stores := make([]*vault.Keystore, 0, len(paths))
+	for _, contents := range []string{vault.KeystoreContents, vault.TruststoreContents} {
+		if paths[contents] != "" {
+			stores = append(stores, &vault.Keystore{Path: paths[contents], Type: *keystoreType, Contents: contents, PasswordFile: *keystorePass})
+		}
+	}
+	return stores
+}
+
+// newAuthFactory returns a factory for --auth-method. Anything a
+// later process needs to log in again is saved alongside tokenPath.
+func newAuthFactory(vaultConfig *vault.VaultConfig, tokenPath string) (vault.ClientFactory, error) {
+	switch *authMethod {
+	case "approle":
+		appRoleConfig := &vault.AppRoleAuthConfig{
+			LoginPath:       *loginPath,
+			RoleID:          *roleID,
+			RoleIDFile:      *roleIDFile,
+			SecretID:        *secretID,
+			SecretIDFile:    *secretIDFile,
+			SecretIDWrapped: *secretIDWrapped,
+		}
+		if appRoleConfig.LoginPath == "" {
+			appRoleConfig.LoginPath = "approle/login"
+		}
+		if appRoleConfig.SecretIDWrapped && tokenPath != "" {
+			appRoleConfig.UnwrappedSecretIDFile = tokenPath + ".secret-id"
+		}
+		return vault.NewAppRoleAuthClientFactory(vaultConfig, appRoleConfig), nil
+	case "jwt":
+		jwtConfig := &vault.JWTAuthConfig{
+			LoginPath: *loginPath,
+			Role:      *authRole,
+			JWT:       *jwt,
+			JWTFile:   *jwtFile,
+		}
+		if jwtConfig.LoginPath == "" {
+			jwtConfig.LoginPath = "jwt/login"
+		}
+		return vault.NewJWTAuthClientFactory(vaultConfig, jwtConfig), nil
+	case "cert":
+		certConfig := &vault.CertAuthConfig{
+			LoginPath: *loginPath,
+			Role:      *authRole,
+		}
+		if certConfig.LoginPath == "" {
+			certConfig.LoginPath = "cert/login"
+		}
+		return vault.NewCertAuthClientFactory(vaultConfig, certConfig), nil
+	case "aws":
+		awsConfig := &vault.AWSAuthConfig{
+			LoginPath: *loginPath,
+			Role:      *authRole,
+			Region:    *awsRegion,
+			ServerID:  *awsServerID,
+		}
+		if awsConfig.LoginPath == "" {
+			awsConfig.LoginPath = "aws/login"
+		}
+		return vault.NewAWSAuthClientFactory(vaultConfig, awsConfig), nil
+	case "gcp":
+		gcpConfig := &vault.GCPAuthConfig{
+			LoginPath:      *loginPath,
+			Role:           *authRole,
+			ServiceAccount: *gcpServiceAccount,
+			MetadataAddr:   *metadataAddr,
+		}
+		if gcpConfig.LoginPath == "" {
+			gcpConfig.LoginPath = "gcp/login"
+		}
+		return vault.NewGCPAuthClientFactory(vaultConfig, gcpConfig), nil
+	case "azure":
+		azureConfig := &vault.AzureAuthConfig{
+			LoginPath:    *loginPath,
+			Role:         *authRole,
+			Resource:     *azureResource,
+			ClientID:     *azureClientID,
+			MetadataAddr: *metadataAddr,
+		}
+		if azureConfig.LoginPath == "" {
+			azureConfig.LoginPath = "azure/login"
+		}
+		return vault.NewAzureAuthClientFactory(vaultConfig, azureConfig), nil
+	case "agent":
+		if *agentSink == "" {
+			return nil, fmt.Errorf("--agent-sink is required for agent authentication")
+		}
+		return vault.NewAgentAuthClientFactory(vaultConfig, &vault.AgentAuthConfig{SinkFile: *agentSink}), nil
+	case "token":
+		tokenConfig := &vault.TokenAuthConfig{
+			Token:          *vaultToken,
+			TokenFile:      *vaultTokenFile,
+			LoginPath:      *loginPath,
+			Username:       *username,
+			PasswordPrompt: promptPassword,
+		}
+		if tokenConfig.Token == "" && tokenConfig.TokenFile == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return nil, fmt.Errorf("error finding home directory: %s", err)
+			}
+			tokenConfig.TokenFile = filepath.Join(home, ".vault-token")
+		}
+		if tokenConfig.LoginPath == "" {
+			tokenConfig.LoginPath = "userpass/login"
+		}
+		return vault.NewTokenAuthClientFactory(vaultConfig, tokenConfig), nil
+	}
+
+	if *loginPath == "" || *authRole == "" {
+		return nil, fmt.Errorf("--login-path and --auth-role are required for kubernetes authentication")
+	}
+	kubernetesConfig := &vault.KubernetesAuthConfig{
+		TokenFile: *serviceAccountToken,
+		LoginPath: *loginPath,
+		Role:      *authRole,
+	}
+
+	if *tokenAudience != "" {
+		tokenSource, err := newTokenRequester()
+		if err != nil {
+			return nil, err
+		}
+		kubernetesConfig.TokenSource = tokenSource
+	}
+
+	return vault.NewKubernetesAuthClientFactory(vaultConfig, kubernetesConfig), nil
+}
+
+func newTokenRequester() (*kube.TokenRequester, error) {
+	saNamespace, saName := namespace, *serviceAccount
+	if saNamespace == "" || saName == "" {
+		tokenNamespace, tokenName, err := kube.ServiceAccountFromToken(*serviceAccountToken)
+		if err != nil {
+			return nil, fmt.Errorf("error finding service account: %s", err)
+		}
+		if saNamespace == "" {
+			saNamespace = tokenNamespace
+		}
+		if saName == "" {
+			saName = tokenName
+		}
+	}
+
+	return kube.NewTokenRequester(saNamespace, saName, *tokenAudience, *tokenExpiry)
+}
+
+func promptPassword() (string, error) {
+	fmt.Fprintf(os.Stderr, "Password for %s: ", *username)
+	password, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	return string(password), err
+}
+
+func fileExists(path string) bool {
+	if path == "" {
+		return false
+	}
+	_, err := os.Stat(path)
+	return err == nil
+}
+
 func main() {
 	kingpin.Parse()
 
@@ -83,12 +383,24 @@ func main() {
 	logger := log.WithFields(log.Fields{"gitSHA": SHA})
 	logger.Infof("started application")
 
-	t, err := template.ParseFiles(*templateFile)
+	cfg, err := loadConfig()
 	if err != nil {
-		log.Fatal("error opening template:", err)
+		log.Fatal("error loading config: ", err)
 	}
 
-	var vaultTLS vault.TLSConfig
+	if *renewFraction <= 0 || *renewFraction >= 1 {
+		log.Fatal("error: renew fraction must be between 0 and 1")
+	}
+	if *renewJitter < 0 || *renewJitter >= 1 {
+		log.Fatal("error: renew jitter must be between 0 and 1")
+	}
+
+	vaultTLS := vault.TLSConfig{
+		ClientCert: *clientCert,
+		ClientKey:  *clientKey,
+		ServerName: *tlsServerName,
+		MinVersion: *tlsMinVersion,
+	}
 
 	if *caCert != "" {
 		fi, err := os.Stat(*caCert)
@@ -102,79 +414,117 @@ func main() {
 		}
 	}
 
-	var secretType vault.SecretType
-
-	options := make(map[string]string, 0)
-
-	if *getCertificate && *commonName == "" {
-		log.Fatal("error: must supply common name when requesting certificate")
-	} else if *getCertificate && *commonName != "" {
-		secretType = vault.CertificateType
-
-		options["common_name"] = *commonName
-		options["ttl"] = *ttl
-	} else {
-		secretType = vault.CredentialType
-	}
-
 	vaultConfig := &vault.VaultConfig{
-		VaultAddr: *vaultAddr,
-		TLS:       &vaultTLS,
+		TLS:           &vaultTLS,
+		Namespace:     *vaultNamespace,
+		AuthNamespace: *authNamespace,
 	}
-	kubernetesConfig := &vault.KubernetesAuthConfig{
-		TokenFile: *serviceAccountToken,
-		LoginPath: *loginPath,
-		Role:      *authRole,
+	if len(*vaultAddr) > 0 {
+		vaultConfig.VaultAddr = (*vaultAddr)[0]
+		vaultConfig.FailoverAddrs = (*vaultAddr)[1:]
 	}
-
 	gateway := metrics.NewPushGateway(*gatewayAddr)
 
-	leasePath := *out + ".lease"
-	tokenPath := *out + ".token"
-	if _, err = os.Stat(leasePath); err == nil {
-		leaseExist = true
-	}
+	leasePaths := cfg.LeasePaths()
+	tokenExist := fileExists(cfg.TokenPath)
 
-	if leaseExist && *initMode {
-		cleanUp(leasePath, tokenPath, gateway.Pusher)
+	if tokenExist && *initMode {
+		cleanUp(leasePaths, cfg.TokenPath, gateway.Pusher)
 		log.Fatal("lease detected while in init mode, shutting down and cleaning up")
 	}
 
-	var factory vault.ClientFactory
-	if leaseExist {
-		factory = vault.NewFileAuthClientFactory(vaultConfig, tokenPath)
-	} else {
-		factory = vault.NewKubernetesAuthClientFactory(vaultConfig, kubernetesConfig)
+	factory, err := newAuthFactory(vaultConfig, cfg.TokenPath)
+	if err != nil {
+		log.Fatal("error configuring authentication: ", err)
+	}
+	// the agent's sink always holds its current token, and a token
+	// given directly isn't ours to renew or revoke, so neither is
+	// restored from the token file
+	externalToken := *authMethod == "agent" || (*authMethod == "token" && *username == "")
+	if tokenExist && !externalToken {
+		factory = vault.NewFileAuthClientFactory(vaultConfig, cfg.TokenPath, factory)
 	}
 
 	authClient, err := factory.Create()
 	if err != nil {
 		log.Fatal("error creating client:", err)
 	}
-
-	var secretsProvider vault.SecretsProvider
-
-	// if there's already a lease, use that and don't generate new credentials
-	if *getCertificate || !leaseExist {
-		secretsProvider = vault.NewVaultSecretsProvider(authClient.Client, secretType, *secretPath, options)
-	} else {
-		secretsProvider = vault.NewFileSecretsProvider(secretType, leasePath, options)
+	log.Infof("using vault address %s", authClient.Client.Address())
+
+	err = authClient.LookupSelf()
+	if err == vault.ErrPermissionDenied && tokenExist && !externalToken && authClient.CanLogin() {
+		// the restored token has expired or been revoked, and its
+		// leases with it, so log in again and request new secrets
+		log.Warnf("restored vault token is no longer valid")
+		err = authClient.Reauthenticate(authClient.Client.Token())
+		if err != nil {
+			log.Fatal("error logging in again: ", err)
+		}
+		tokenExist = false
+		err = authClient.LookupSelf()
 	}
-
-	secret, err := secretsProvider.Fetch()
 	if err != nil {
-		log.Fatalf("failed to retrieve secret: %v", err)
+		log.Warnf("unable to look up vault token, assuming a renewable service token: %s", err)
 	}
+	gateway.SetEndpoint(authClient.Client.Address())
 
-	var manager vault.CredentialsRenewer
+	managers := make([]vault.CredentialsRenewer, 0, len(cfg.Secrets))
+	restored := make([]bool, 0, len(cfg.Secrets))
 
-	if cert, isCert := secret.(*vault.Certificate); isCert {
-		provider, _ := secretsProvider.(*vault.VaultSecretsProvider)
-		renew := time.Until(time.Unix(cert.Expiration, 0)).Round(time.Minute)
+	for _, s := range cfg.Secrets {
+		secretLogger := log.WithField("secret", s.Name)
 
-		manager = vault.NewManager(authClient.Client, secret, *leaseDuration, renew, provider, t, nil, *out)
-	} else {
-		manager = vault.NewManager(authClient.Client, secret, *leaseDuration, *renewInterval, nil, t, gateway, *out)
+		var t *template.Template
+		if s.Template != "" {
+			t, err = template.ParseFiles(s.Template)
+			if err != nil {
+				secretLogger.Fatal("error opening template:", err)
+			}
+		}
+
+		options := s.Options()
+
+		// if there's already a lease, use that and don't generate new
+		// credentials. A token that isn't ours is never saved, so its
+		// leases are restored whenever they exist.
+		leaseExist := (tokenExist || externalToken) && s.Type.IsLeased() && fileExists(s.LeasePath)
+
+		vaultProvider := vault.NewVaultSecretsProvider(authClient.Client, s.Type, s.Path, options)
+		provider, _ := vaultProvider.(*vault.VaultSecretsProvider)
+
+		secretsProvider := vaultProvider
+		if leaseExist {
+			secretsProvider = vault.NewFileSecretsProvider(s.Type, s.LeasePath, options)
+		}
+
+		secret, err := secretsProvider.Fetch()
+		if err != nil {
+			secretLogger.Fatalf("failed to retrieve secret: %v", err)
+		}
+
+		// secrets given by flags keep the unlabelled metrics they've
+		// always reported, only secrets from a config file are named
+		secretGateway := gateway.Copy()
+		if *configFile != "" {
+			secretGateway = gateway.WithSecret(s.Name)
+		}
+
+		managerConfig := vault.ManagerConfig{
+			Lease:         s.LeaseDuration,
+			Renew:         s.RenewInterval,
+			RenewFraction: s.RenewFraction,
+			RenewJitter:   *renewJitter,
+			RevokeGrace:   s.RevokeGrace,
+			OutPath:       s.Out,
+			LeasePath:     s.LeasePath,
+			Files:         s.Files,
+			Keystores:     s.Keystores,
+		}
+
+		manager := vault.NewManager(authClient, secret, provider, t, secretGateway, managerConfig)
+
+		managers = append(managers, manager)
+		restored = append(restored, leaseExist)
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -184,7 +534,17 @@ func main() {
 
 	errChan := make(chan int)
 
-	go manager.Run(ctx, errChan)
+	tokenManager := vault.NewTokenManager(authClient, gateway.Copy(), vault.TokenManagerConfig{
+		Increment:     *tokenDuration,
+		Renew:         *tokenRenew,
+		RenewFraction: *renewFraction,
+		RenewJitter:   *renewJitter,
+	})
+	go tokenManager.Run(ctx, errChan)
+
+	for _, manager := range managers {
+		go manager.Run(ctx, errChan)
+	}
 
 	if *job {
 		checker, err := kube.NewKubeChecker(podName, namespace)
@@ -199,7 +559,7 @@ func main() {
 			select {
 			case errVal := <-errChan:
 				if errVal == 1 { //something wrong with the lease/token
-					cleanUp(leasePath, tokenPath, gateway.Pusher)
+					cleanUp(leasePaths, cfg.TokenPath, gateway.Pusher)
 					log.Fatal("fatal error shutting down")
 				} else if errVal == 2 { //something wrong with another container
 					log.Fatal("shutting down")
@@ -210,31 +570,36 @@ func main() {
 		}
 	}()
 
-	if *out != "" && !leaseExist {
+	for i, manager := range managers {
+		if restored[i] {
+			continue
+		}
+
 		err = manager.Save()
 		if err != nil {
-			cleanUp(leasePath, tokenPath, gateway.Pusher)
+			cleanUp(leasePaths, cfg.TokenPath, gateway.Pusher)
 			log.Fatal(err)
 		}
+	}
 
-		err = authClient.Save(tokenPath)
+	// a token that isn't ours isn't persisted alongside the secrets
+	if cfg.TokenPath != "" && !tokenExist && !externalToken {
+		err = authClient.Save(cfg.TokenPath)
 		if err != nil {
-			cleanUp(leasePath, tokenPath, gateway.Pusher)
+			cleanUp(leasePaths, cfg.TokenPath, gateway.Pusher)
 			log.Fatal(err)
 		}
+	}
 
-		if *initMode {
-			log.Infof("completed init")
-			c <- os.Interrupt
-		}
-	} else if !leaseExist {
-		t.Execute(os.Stdout, secret.EnvVars())
+	if *initMode {
+		log.Infof("completed init")
+		c <- os.Interrupt
 	}
 
 	<-c
 	if !*initMode {
-		manager.RevokeSelf(ctx)
-		cleanUp(leasePath, tokenPath, gateway.Pusher)
+		authClient.RevokeSelf()
+		cleanUp(leasePaths, cfg.TokenPath, gateway.Pusher)
 	}
 	log.Infof("shutting down")
 	cancel()
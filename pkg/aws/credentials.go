@@ -0,0 +1,311 @@
+package aws
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	// addresses of the ECS task and EC2 instance metadata services
+	containerCredentialsAddr = "http://169.254.170.2"
+	instanceMetadataAddr     = "http://169.254.169.254"
+	// stsAddr is the global STS endpoint, used for web identities
+	// when no region is set
+	stsAddr = "https://sts.amazonaws.com"
+
+	metadataClient = &http.Client{Timeout: 5 * time.Second}
+)
+
+// Credentials are the AWS access keys used to sign requests
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// LoadCredentials finds credentials in the same order as the AWS
+// SDKs: the environment, the shared credentials file, a web identity
+// token such as the one EKS gives service accounts, the container
+// credentials of ECS and EKS Pod Identity and finally the EC2
+// instance profile. They are loaded again every time as temporary
+// credentials expire.
+func LoadCredentials() (*Credentials, error) {
+	if creds := fromEnvironment(); creds != nil {
+		return creds, nil
+	}
+
+	creds, err := fromSharedFile()
+	if err != nil {
+		return nil, err
+	}
+	if creds != nil {
+		return creds, nil
+	}
+
+	if tokenFile := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"); tokenFile != "" {
+		return fromWebIdentity(tokenFile, os.Getenv("AWS_ROLE_ARN"))
+	}
+
+	if uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); uri != "" {
+		return fromContainer(containerCredentialsAddr + uri)
+	}
+	if uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI"); uri != "" {
+		return fromContainer(uri)
+	}
+
+	return fromInstanceMetadata()
+}
+
+func fromEnvironment() *Credentials {
+	creds := &Credentials{
+		AccessKeyID:     firstEnv("AWS_ACCESS_KEY_ID", "AWS_ACCESS_KEY"),
+		SecretAccessKey: firstEnv("AWS_SECRET_ACCESS_KEY", "AWS_SECRET_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return nil
+	}
+	return creds
+}
+
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// fromSharedFile reads the profile named by AWS_PROFILE from the
+// shared credentials file, returning nil if there is no file
+func fromSharedFile() (*Credentials, error) {
+	path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, nil
+		}
+		path = filepath.Join(home, ".aws", "credentials")
+	}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading aws credentials: %s", err)
+	}
+	defer file.Close()
+
+	profile := firstEnv("AWS_PROFILE", "AWS_DEFAULT_PROFILE")
+	if profile == "" {
+		profile = "default"
+	}
+
+	var creds Credentials
+	section := ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		if section != profile {
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(parts[1])
+		switch strings.TrimSpace(parts[0]) {
+		case "aws_access_key_id":
+			creds.AccessKeyID = value
+		case "aws_secret_access_key":
+			creds.SecretAccessKey = value
+		case "aws_session_token":
+			creds.SessionToken = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading aws credentials: %s", err)
+	}
+
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return nil, nil
+	}
+
+	return &creds, nil
+}
+
+// metadataCredentials is the format of credentials returned by both
+// the ECS and EC2 metadata services
+type metadataCredentials struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	Token           string `json:"Token"`
+}
+
+// fromContainer requests credentials from the ECS or EKS Pod Identity
+// agent, which may require an authorization token
+func fromContainer(uri string) (*Credentials, error) {
+	req, err := http.NewRequest("GET", uri, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")
+	if file := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"); file != "" {
+		bytes, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("error reading container authorization token: %s", err)
+		}
+		token = strings.TrimSpace(string(bytes))
+	}
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+
+	return fetchMetadataCredentials(req)
+}
+
+// webIdentityResponse is the part of the STS AssumeRoleWithWebIdentity
+// response holding the credentials
+type webIdentityResponse struct {
+	Credentials struct {
+		AccessKeyID     string `xml:"AccessKeyId"`
+		SecretAccessKey string `xml:"SecretAccessKey"`
+		SessionToken    string `xml:"SessionToken"`
+	} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+}
+
+// fromWebIdentity exchanges the token in tokenFile for credentials of
+// roleARN. The token file is read every time as it is rotated.
+func fromWebIdentity(tokenFile, roleARN string) (*Credentials, error) {
+	if roleARN == "" {
+		return nil, fmt.Errorf("AWS_ROLE_ARN is required with AWS_WEB_IDENTITY_TOKEN_FILE")
+	}
+
+	token, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading web identity token: %s", err)
+	}
+
+	sessionName := os.Getenv("AWS_ROLE_SESSION_NAME")
+	if sessionName == "" {
+		sessionName = fmt.Sprintf("vault-creds-%d", time.Now().UnixNano())
+	}
+
+	endpoint := stsAddr
+	if region := firstEnv("AWS_REGION", "AWS_DEFAULT_REGION"); region != "" {
+		endpoint = fmt.Sprintf("https://sts.%s.amazonaws.com", region)
+	}
+
+	form := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {roleARN},
+		"RoleSessionName":  {sessionName},
+		"WebIdentityToken": {strings.TrimSpace(string(token))},
+	}
+	req, err := http.NewRequest("POST", endpoint+"/", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	body, err := metadataRequest(req)
+	if err != nil {
+		return nil, fmt.Errorf("error assuming role with web identity: %s", err)
+	}
+
+	var resp webIdentityResponse
+	err = xml.Unmarshal(body, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing web identity credentials: %s", err)
+	}
+
+	creds := resp.Credentials
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return nil, fmt.Errorf("web identity response did not contain credentials")
+	}
+
+	return &Credentials{AccessKeyID: creds.AccessKeyID, SecretAccessKey: creds.SecretAccessKey, SessionToken: creds.SessionToken}, nil
+}
+
+// fromInstanceMetadata requests the instance profile credentials
+// using an IMDSv2 session token
+func fromInstanceMetadata() (*Credentials, error) {
+	req, err := http.NewRequest("PUT", instanceMetadataAddr+"/latest/api/token", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "60")
+	token, err := metadataRequest(req)
+	if err != nil {
+		return nil, fmt.Errorf("no aws credentials found, error requesting instance metadata token: %s", err)
+	}
+
+	req, err = http.NewRequest("GET", instanceMetadataAddr+"/latest/meta-data/iam/security-credentials/", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token", string(token))
+	role, err := metadataRequest(req)
+	if err != nil {
+		return nil, fmt.Errorf("error finding instance profile: %s", err)
+	}
+
+	name := strings.TrimSpace(strings.SplitN(string(role), "\n", 2)[0])
+	req, err = http.NewRequest("GET", instanceMetadataAddr+"/latest/meta-data/iam/security-credentials/"+name, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token", string(token))
+
+	return fetchMetadataCredentials(req)
+}
+
+func fetchMetadataCredentials(req *http.Request) (*Credentials, error) {
+	body, err := metadataRequest(req)
+	if err != nil {
+		return nil, fmt.Errorf("error requesting aws credentials: %s", err)
+	}
+
+	var creds metadataCredentials
+	err = json.Unmarshal(body, &creds)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing aws credentials: %s", err)
+	}
+
+	return &Credentials{AccessKeyID: creds.AccessKeyID, SecretAccessKey: creds.SecretAccessKey, SessionToken: creds.Token}, nil
+}
+
+func metadataRequest(req *http.Request) ([]byte, error) {
+	resp, err := metadataClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
@@ -0,0 +1,134 @@
+package aws
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestCredentialsFromEnvironment(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SESSION_TOKEN", "session")
+
+	creds, err := LoadCredentials()
+	if err != nil {
+		t.Fatalf("error loading credentials: %v", err)
+	}
+	if creds.AccessKeyID != "AKID" || creds.SecretAccessKey != "secret" || creds.SessionToken != "session" {
+		t.Errorf("unexpected credentials %+v", creds)
+	}
+}
+
+func TestCredentialsFromSharedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials")
+	err := ioutil.WriteFile(path, []byte(`[default]
+aws_access_key_id = AKIDDEFAULT
+aws_secret_access_key = default
+
+# comment
+[ci]
+aws_access_key_id = AKIDCI
+aws_secret_access_key = ci
+aws_session_token = session
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", path)
+	t.Setenv("AWS_PROFILE", "ci")
+
+	creds, err := LoadCredentials()
+	if err != nil {
+		t.Fatalf("error loading credentials: %v", err)
+	}
+	if creds.AccessKeyID != "AKIDCI" || creds.SecretAccessKey != "ci" || creds.SessionToken != "session" {
+		t.Errorf("unexpected credentials %+v", creds)
+	}
+}
+
+// withoutStaticCredentials clears the environment and shared file so
+// that LoadCredentials falls through to the other sources
+func withoutStaticCredentials(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+	t.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", "")
+	t.Setenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI", "")
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_DEFAULT_REGION", "")
+}
+
+func TestCredentialsFromWebIdentity(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("Action") != "AssumeRoleWithWebIdentity" || r.Form.Get("RoleArn") != "arn:aws:iam::123456789012:role/app" || r.Form.Get("WebIdentityToken") != "jwt" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`<AssumeRoleWithWebIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleWithWebIdentityResult>
+    <Credentials>
+      <AccessKeyId>AKIDWEB</AccessKeyId>
+      <SecretAccessKey>web</SecretAccessKey>
+      <SessionToken>session</SessionToken>
+    </Credentials>
+  </AssumeRoleWithWebIdentityResult>
+</AssumeRoleWithWebIdentityResponse>`))
+	}))
+	defer server.Close()
+	defer func(addr string) { stsAddr = addr }(stsAddr)
+	stsAddr = server.URL
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	err := ioutil.WriteFile(tokenFile, []byte("jwt\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withoutStaticCredentials(t)
+	t.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", tokenFile)
+	t.Setenv("AWS_ROLE_ARN", "arn:aws:iam::123456789012:role/app")
+
+	creds, err := LoadCredentials()
+	if err != nil {
+		t.Fatalf("error loading credentials: %v", err)
+	}
+	if creds.AccessKeyID != "AKIDWEB" || creds.SecretAccessKey != "web" || creds.SessionToken != "session" {
+		t.Errorf("unexpected credentials %+v", creds)
+	}
+}
+
+func TestCredentialsFromContainerFullURI(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/credentials" || r.Header.Get("Authorization") != "pod-identity" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"AccessKeyId": "AKIDPOD", "SecretAccessKey": "pod", "Token": "session"}`))
+	}))
+	defer server.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	err := ioutil.WriteFile(tokenFile, []byte("pod-identity\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withoutStaticCredentials(t)
+	t.Setenv("AWS_CONTAINER_CREDENTIALS_FULL_URI", server.URL+"/v1/credentials")
+	t.Setenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE", tokenFile)
+
+	creds, err := LoadCredentials()
+	if err != nil {
+		t.Fatalf("error loading credentials: %v", err)
+	}
+	if creds.AccessKeyID != "AKIDPOD" || creds.SecretAccessKey != "pod" || creds.SessionToken != "session" {
+		t.Errorf("unexpected credentials %+v", creds)
+	}
+}
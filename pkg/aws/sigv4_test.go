@@ -0,0 +1,46 @@
+package aws
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	testCredentials = &Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	testTime        = time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+)
+
+// get-vanilla from the AWS Signature Version 4 test suite
+func TestSignGetVanilla(t *testing.T) {
+	req, _ := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	Sign(req, nil, testCredentials, "us-east-1", "service", testTime)
+
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if req.Header.Get("Authorization") != expected {
+		t.Errorf("expected %s got %s", expected, req.Header.Get("Authorization"))
+	}
+	if req.Header.Get("X-Amz-Date") != "20150830T123600Z" {
+		t.Errorf("unexpected date %s", req.Header.Get("X-Amz-Date"))
+	}
+}
+
+func TestSignWithSessionToken(t *testing.T) {
+	body := "Action=GetCallerIdentity&Version=2011-06-15"
+	req, _ := http.NewRequest("POST", "https://sts.amazonaws.com/", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	req.Header.Set("X-Vault-AWS-IAM-Server-ID", "vault.example.com")
+
+	creds := *testCredentials
+	creds.SessionToken = "session"
+	Sign(req, []byte(body), &creds, "us-east-1", "sts", testTime)
+
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/sts/aws4_request, SignedHeaders=content-type;host;x-amz-date;x-amz-security-token;x-vault-aws-iam-server-id, Signature=678a5330a80677f43610e4d3e0d32ba128696424dbf5bf63ec2511d8867ba26a"
+	if req.Header.Get("Authorization") != expected {
+		t.Errorf("expected %s got %s", expected, req.Header.Get("Authorization"))
+	}
+	if req.Header.Get("X-Amz-Security-Token") != "session" {
+		t.Errorf("expected session token header to be set")
+	}
+}
@@ -0,0 +1,336 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/uswitch/vault-creds/pkg/vault"
+	yaml "gopkg.in/yaml.v1"
+)
+
+// defaultRenewFraction matches the --renew-fraction flag and is used
+// when neither the secret nor its defaults give a fraction
+const defaultRenewFraction = 0.67
+
+// Config lists every secret a single vault-creds process
+// manages. All secrets share one authenticated Vault client.
+type Config struct {
+	TokenPath string    `yaml:"token-path"`
+	Secrets   []*Secret `yaml:"secrets"`
+}
+
+// Secret describes a single secret to request from Vault, the
+// template used to render it and where the result is written.
+type Secret struct {
+	Name       string           `yaml:"name"`
+	Path       string           `yaml:"path"`
+	Type       vault.SecretType `yaml:"type"`
+	Template   string           `yaml:"template"`
+	Out        string           `yaml:"out"`
+	LeasePath  string           `yaml:"lease-path"`
+	CommonName string           `yaml:"common-name"`
+	TTL        string           `yaml:"ttl"`
+	Version    int              `yaml:"version"`
+
+	AltNames          []string `yaml:"alt-names"`
+	IPSANs            []string `yaml:"ip-sans"`
+	URISANs           []string `yaml:"uri-sans"`
+	OtherSANs         []string `yaml:"other-sans"`
+	ExcludeCNFromSANs bool     `yaml:"exclude-cn-from-sans"`
+	Format            string   `yaml:"format"`
+	PrivateKeyFormat  string   `yaml:"private-key-format"`
+	IssuerRef         string   `yaml:"issuer-ref"`
+	KeyType           string   `yaml:"key-type"`
+	KeyBits           int      `yaml:"key-bits"`
+
+	Files     []*vault.CertificateFile `yaml:"files"`
+	Keystores []*vault.Keystore        `yaml:"keystores"`
+
+	RenewFraction float64 `yaml:"renew-fraction"`
+
+	RawLeaseDuration string `yaml:"lease-duration"`
+	RawRenewInterval string `yaml:"renew-interval"`
+	RawRevokeGrace   string `yaml:"revoke-grace"`
+
+	LeaseDuration time.Duration `yaml:"-"`
+	RenewInterval time.Duration `yaml:"-"`
+	RevokeGrace   time.Duration `yaml:"-"`
+}
+
+// Load reads the config file at path. Any setting not given for
+// a secret is taken from defaults.
+func Load(path string, defaults *Secret) (*Config, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config: %v", err)
+	}
+
+	var cfg Config
+	err = yaml.Unmarshal(bytes, &cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling config: %v", err)
+	}
+
+	err = cfg.complete(defaults)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+// FromSecret builds a config managing a single secret, this is
+// used when vault-creds is configured entirely by flags.
+func FromSecret(secret *Secret, tokenPath string) (*Config, error) {
+	cfg := &Config{TokenPath: tokenPath, Secrets: []*Secret{secret}}
+
+	err := cfg.complete(&Secret{})
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// LeasePaths returns the lease file of every secret written to disk
+func (c *Config) LeasePaths() []string {
+	paths := make([]string, 0, len(c.Secrets))
+	for _, s := range c.Secrets {
+		if s.LeasePath != "" {
+			paths = append(paths, s.LeasePath)
+		}
+	}
+	return paths
+}
+
+func (c *Config) complete(defaults *Secret) error {
+	if len(c.Secrets) == 0 {
+		return fmt.Errorf("no secrets configured")
+	}
+
+	names := make(map[string]bool)
+	outs := make(map[string]bool)
+
+	for i, s := range c.Secrets {
+		err := s.complete(defaults)
+		if err != nil {
+			return fmt.Errorf("secret %d: %v", i, err)
+		}
+
+		if names[s.Name] {
+			return fmt.Errorf("secret %d: duplicate name %s", i, s.Name)
+		}
+		names[s.Name] = true
+
+		for _, path := range s.outputs() {
+			if outs[path] {
+				return fmt.Errorf("secret %s: duplicate output %s", s.Name, path)
+			}
+			outs[path] = true
+		}
+	}
+
+	// tokens were historically stored alongside the first output,
+	// they are kept beside the first lease so that they are never
+	// written into a directory of certificate files
+	if c.TokenPath == "" {
+		for _, s := range c.Secrets {
+			if s.LeasePath != "" {
+				c.TokenPath = strings.TrimSuffix(s.LeasePath, ".lease") + ".token"
+				break
+			}
+		}
+	}
+	if c.TokenPath == "" && len(outs) > 0 {
+		return fmt.Errorf("token-path is required when no secret has an out or lease-path")
+	}
+
+	return nil
+}
+
+func (s *Secret) complete(defaults *Secret) error {
+	if s.Path == "" {
+		return fmt.Errorf("path is required")
+	}
+	if s.Name == "" {
+		s.Name = s.Path
+	}
+	if s.Type == "" {
+		s.Type = defaults.Type
+	}
+	if s.Type == "" {
+		s.Type = vault.CredentialType
+	}
+	switch s.Type {
+	case vault.CredentialType, vault.CertificateType, vault.DynamicType, vault.KVType:
+	default:
+		return fmt.Errorf("unknown secret type %s", s.Type)
+	}
+	if s.Template == "" {
+		s.Template = defaults.Template
+	}
+	if s.Template == "" && len(s.Files) == 0 && len(s.Keystores) == 0 {
+		return fmt.Errorf("template is required")
+	}
+	if s.LeasePath == "" && s.Out != "" {
+		s.LeasePath = s.Out + ".lease"
+	}
+	if s.Type == vault.CertificateType && s.CommonName == "" {
+		return fmt.Errorf("must supply common name when requesting certificate")
+	}
+	switch s.Format {
+	case "", "pem", "der", "pem_bundle":
+	default:
+		return fmt.Errorf("unknown certificate format %s", s.Format)
+	}
+	switch s.PrivateKeyFormat {
+	case "", "der", "pem", "pkcs8":
+	default:
+		return fmt.Errorf("unknown private key format %s", s.PrivateKeyFormat)
+	}
+	if len(s.Files) > 0 || len(s.Keystores) > 0 {
+		if s.Type != vault.CertificateType {
+			return fmt.Errorf("files and keystores can only be written for certificates")
+		}
+		if s.Format != "" && s.Format != "pem" {
+			return fmt.Errorf("files and keystores can only be written for pem certificates")
+		}
+	}
+	for _, f := range s.Files {
+		if f.Path == "" {
+			return fmt.Errorf("file path is required")
+		}
+		switch f.Contents {
+		case vault.CertificateContents, vault.PrivateKeyContents, vault.CAContents, vault.FullChainContents:
+		default:
+			return fmt.Errorf("unknown contents %s for file %s", f.Contents, f.Path)
+		}
+	}
+	for _, k := range s.Keystores {
+		if k.Path == "" {
+			return fmt.Errorf("keystore path is required")
+		}
+		if k.Type == "" {
+			k.Type = vault.PKCS12Keystore
+		}
+		if k.Type != vault.PKCS12Keystore && k.Type != vault.JKSKeystore {
+			return fmt.Errorf("unknown type %s for keystore %s", k.Type, k.Path)
+		}
+		if k.Contents == "" {
+			k.Contents = vault.KeystoreContents
+		}
+		if k.Contents != vault.KeystoreContents && k.Contents != vault.TruststoreContents {
+			return fmt.Errorf("unknown contents %s for keystore %s", k.Contents, k.Path)
+		}
+	}
+	switch s.KeyType {
+	case "":
+	case "rsa", "ec", "ed25519":
+		if s.Type != vault.CertificateType || !strings.Contains(s.Path, "/sign/") {
+			return fmt.Errorf("key-type requires a certificate requested from <mount>/sign/<role>")
+		}
+	default:
+		return fmt.Errorf("unknown key type %s", s.KeyType)
+	}
+
+	if s.RenewFraction == 0 {
+		s.RenewFraction = defaults.RenewFraction
+	}
+	if s.RenewFraction == 0 {
+		s.RenewFraction = defaultRenewFraction
+	}
+	if s.RenewFraction <= 0 || s.RenewFraction >= 1 {
+		return fmt.Errorf("renew-fraction must be between 0 and 1")
+	}
+
+	var err error
+	s.LeaseDuration, err = parseDuration(s.RawLeaseDuration, s.LeaseDuration, defaults.LeaseDuration)
+	if err != nil {
+		return fmt.Errorf("invalid lease-duration: %v", err)
+	}
+	s.RenewInterval, err = parseDuration(s.RawRenewInterval, s.RenewInterval, defaults.RenewInterval)
+	if err != nil {
+		return fmt.Errorf("invalid renew-interval: %v", err)
+	}
+	s.RevokeGrace, err = parseDuration(s.RawRevokeGrace, s.RevokeGrace, defaults.RevokeGrace)
+	if err != nil {
+		return fmt.Errorf("invalid revoke-grace: %v", err)
+	}
+
+	return nil
+}
+
+// outputs returns every file the secret is written to other than
+// its lease
+func (s *Secret) outputs() []string {
+	var paths []string
+	if s.Out != "" {
+		paths = append(paths, s.Out)
+	}
+	for _, f := range s.Files {
+		paths = append(paths, f.Path)
+	}
+	for _, k := range s.Keystores {
+		paths = append(paths, k.Path)
+	}
+	return paths
+}
+
+// Options returns the parameters sent to Vault when requesting the secret
+func (s *Secret) Options() map[string]string {
+	options := make(map[string]string, 0)
+
+	if s.Type == vault.CertificateType {
+		options["common_name"] = s.CommonName
+		options["ttl"] = s.TTL
+		setList(options, "alt_names", s.AltNames)
+		setList(options, "ip_sans", s.IPSANs)
+		setList(options, "uri_sans", s.URISANs)
+		setList(options, "other_sans", s.OtherSANs)
+		if s.ExcludeCNFromSANs {
+			options["exclude_cn_from_sans"] = "true"
+		}
+		if s.Format != "" {
+			options["format"] = s.Format
+		}
+		if s.PrivateKeyFormat != "" {
+			options["private_key_format"] = s.PrivateKeyFormat
+		}
+		if s.IssuerRef != "" {
+			options["issuer_ref"] = s.IssuerRef
+		}
+		if s.KeyType != "" {
+			options["key_type"] = s.KeyType
+		}
+		if s.KeyBits != 0 {
+			options["key_bits"] = strconv.Itoa(s.KeyBits)
+		}
+	}
+
+	if s.Type == vault.KVType && s.Version != 0 {
+		options["version"] = strconv.Itoa(s.Version)
+	}
+
+	return options
+}
+
+// setList adds values to options as the comma separated list Vault
+// expects, leaving the parameter out if there are none
+func setList(options map[string]string, key string, values []string) {
+	if len(values) > 0 {
+		options[key] = strings.Join(values, ",")
+	}
+}
+
+func parseDuration(raw string, current, fallback time.Duration) (time.Duration, error) {
+	if raw != "" {
+		return time.ParseDuration(raw)
+	}
+	if current != 0 {
+		return current, nil
+	}
+	return fallback, nil
+}
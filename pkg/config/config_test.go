@@ -0,0 +1,232 @@
+package config
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/uswitch/vault-creds/pkg/vault"
+)
+
+func TestLoad(t *testing.T) {
+	path := "/tmp/testconfig.yml"
+	contents := `
+token-path: /secrets/vault.token
+secrets:
+  - name: readonly
+    path: database/creds/readonly
+    template: ro.tmpl
+    out: /secrets/ro.yml
+  - name: readwrite
+    path: database/creds/readwrite
+    out: /secrets/rw.yml
+    lease-duration: 2h
+    renew-fraction: 0.5
+  - name: cert
+    path: pki/issue/foo
+    type: certificate
+    common-name: foo.example.com
+    ttl: 24h
+    alt-names: [foo.default.svc, foo.default.svc.cluster.local]
+    ip-sans: [10.0.0.1]
+    exclude-cn-from-sans: true
+    issuer-ref: intermediate
+    out: /secrets/cert.pem
+    files:
+      - path: /secrets/tls.key
+        contents: private-key
+        mode: 0640
+`
+	err := ioutil.WriteFile(path, []byte(contents), 0600)
+	if err != nil {
+		t.Fatalf("error writing config: %v", err)
+	}
+
+	cfg, err := Load(path, &Secret{Template: "default.tmpl", LeaseDuration: time.Hour, RenewInterval: 15 * time.Minute, RenewFraction: 0.67})
+	if err != nil {
+		t.Fatalf("error loading config: %v", err)
+	}
+
+	if len(cfg.Secrets) != 3 {
+		t.Fatalf("expected 3 secrets got: %v", len(cfg.Secrets))
+	}
+
+	ro, rw, cert := cfg.Secrets[0], cfg.Secrets[1], cfg.Secrets[2]
+
+	if ro.Template != "ro.tmpl" || rw.Template != "default.tmpl" {
+		t.Errorf("unexpected templates, got: %v, %v", ro.Template, rw.Template)
+	}
+	if ro.LeaseDuration != time.Hour || rw.LeaseDuration != 2*time.Hour {
+		t.Errorf("unexpected lease durations, got: %v, %v", ro.LeaseDuration, rw.LeaseDuration)
+	}
+	if ro.RenewFraction != 0.67 || rw.RenewFraction != 0.5 {
+		t.Errorf("unexpected renew fractions, got: %v, %v", ro.RenewFraction, rw.RenewFraction)
+	}
+	if rw.LeasePath != "/secrets/rw.yml.lease" {
+		t.Errorf("lease path should be /secrets/rw.yml.lease got: %v", rw.LeasePath)
+	}
+	if ro.Type != vault.CredentialType || cert.Type != vault.CertificateType {
+		t.Errorf("unexpected secret types, got: %v, %v", ro.Type, cert.Type)
+	}
+	if cert.Options()["common_name"] != "foo.example.com" {
+		t.Errorf("common name should be foo.example.com got: %v", cert.Options()["common_name"])
+	}
+	if cert.Options()["alt_names"] != "foo.default.svc,foo.default.svc.cluster.local" {
+		t.Errorf("unexpected alt names, got: %v", cert.Options()["alt_names"])
+	}
+	if cert.Options()["ip_sans"] != "10.0.0.1" || cert.Options()["exclude_cn_from_sans"] != "true" || cert.Options()["issuer_ref"] != "intermediate" {
+		t.Errorf("unexpected certificate options, got: %v", cert.Options())
+	}
+	if len(cert.Files) != 1 || cert.Files[0].Path != "/secrets/tls.key" || cert.Files[0].FileMode() != 0640 {
+		t.Errorf("unexpected certificate files, got: %v", cert.Files)
+	}
+	if _, ok := cert.Options()["uri_sans"]; ok {
+		t.Errorf("uri sans should be left out when not configured")
+	}
+	if cfg.TokenPath != "/secrets/vault.token" {
+		t.Errorf("token path should be /secrets/vault.token got: %v", cfg.TokenPath)
+	}
+}
+
+func TestFromSecret(t *testing.T) {
+	cfg, err := FromSecret(&Secret{Path: "database/creds/foo", Template: "foo.tmpl", Out: "/secrets/foo"}, "")
+	if err != nil {
+		t.Fatalf("error building config: %v", err)
+	}
+
+	if cfg.TokenPath != "/secrets/foo.token" {
+		t.Errorf("token path should be /secrets/foo.token got: %v", cfg.TokenPath)
+	}
+	if cfg.Secrets[0].Name != "database/creds/foo" {
+		t.Errorf("name should default to path got: %v", cfg.Secrets[0].Name)
+	}
+
+	_, err = FromSecret(&Secret{Path: "pki/issue/foo", Type: vault.CertificateType, Template: "foo.tmpl"}, "")
+	if err == nil {
+		t.Errorf("expected error for certificate without common name")
+	}
+
+	_, err = FromSecret(&Secret{Path: "pki/issue/foo", Type: vault.CertificateType, CommonName: "foo", KeyType: "ec", Template: "foo.tmpl"}, "")
+	if err == nil {
+		t.Errorf("expected error for generated key without a sign path")
+	}
+
+	cfg, err = FromSecret(&Secret{Path: "pki/sign/foo", Type: vault.CertificateType, CommonName: "foo", KeyType: "ec", KeyBits: 384, Template: "foo.tmpl"}, "")
+	if err != nil {
+		t.Fatalf("error building config: %v", err)
+	}
+	if options := cfg.Secrets[0].Options(); options["key_type"] != "ec" || options["key_bits"] != "384" {
+		t.Errorf("unexpected key options, got: %v", options)
+	}
+}
+
+func TestCertificateFiles(t *testing.T) {
+	_, err := FromSecret(&Secret{Path: "pki/issue/foo", Type: vault.CertificateType, CommonName: "foo", Files: []*vault.CertificateFile{
+		{Path: "/secrets/tls.crt", Contents: vault.CertificateContents},
+	}}, "/secrets/vault.token")
+	if err != nil {
+		t.Errorf("certificates written to files shouldn't need a template: %v", err)
+	}
+
+	_, err = FromSecret(&Secret{Path: "pki/issue/foo", Type: vault.CertificateType, CommonName: "foo", Files: []*vault.CertificateFile{
+		{Path: "/secrets/tls.crt", Contents: "chain"},
+	}}, "/secrets/vault.token")
+	if err == nil {
+		t.Errorf("expected error for unknown file contents")
+	}
+
+	cfg, err := FromSecret(&Secret{Path: "pki/issue/foo", Type: vault.CertificateType, CommonName: "foo", Keystores: []*vault.Keystore{
+		{Path: "/secrets/keystore.p12"},
+	}}, "/secrets/vault.token")
+	if err != nil {
+		t.Fatalf("error building config: %v", err)
+	}
+	if k := cfg.Secrets[0].Keystores[0]; k.Type != vault.PKCS12Keystore || k.Contents != vault.KeystoreContents {
+		t.Errorf("keystore should default to a pkcs12 keystore got: %v, %v", k.Type, k.Contents)
+	}
+
+	_, err = FromSecret(&Secret{Path: "pki/issue/foo", Type: vault.CertificateType, CommonName: "foo", Keystores: []*vault.Keystore{
+		{Path: "/secrets/keystore.bks", Type: "bks"},
+	}}, "/secrets/vault.token")
+	if err == nil {
+		t.Errorf("expected error for unknown keystore type")
+	}
+
+	_, err = FromSecret(&Secret{Path: "database/creds/foo", Template: "foo.tmpl", Files: []*vault.CertificateFile{
+		{Path: "/secrets/tls.crt", Contents: vault.CertificateContents},
+	}}, "/secrets/vault.token")
+	if err == nil {
+		t.Errorf("expected error for files on a credential")
+	}
+}
+
+func TestDuplicateOutputs(t *testing.T) {
+	cfg := &Config{Secrets: []*Secret{
+		{Name: "a", Path: "database/creds/a", Template: "a.tmpl", Out: "/secrets/out"},
+		{Name: "b", Path: "database/creds/b", Template: "b.tmpl", Out: "/secrets/out"},
+	}}
+
+	err := cfg.complete(&Secret{})
+	if err == nil {
+		t.Errorf("expected error for duplicate outputs")
+	}
+}
+
+func TestTokenPathWithoutOut(t *testing.T) {
+	cert := func() *Secret {
+		return &Secret{Name: "cert", Path: "pki/issue/foo", Type: vault.CertificateType, CommonName: "foo", Files: []*vault.CertificateFile{
+			{Path: "/secrets/tls/tls.crt", Contents: vault.CertificateContents},
+		}}
+	}
+
+	cfg := &Config{Secrets: []*Secret{
+		cert(),
+		{Name: "db", Path: "database/creds/foo", Template: "foo.tmpl", Out: "/secrets/db"},
+	}}
+	err := cfg.complete(&Secret{})
+	if err != nil {
+		t.Fatalf("error completing config: %v", err)
+	}
+	if cfg.TokenPath != "/secrets/db.token" {
+		t.Errorf("token path should be /secrets/db.token got: %v", cfg.TokenPath)
+	}
+
+	withLease := cert()
+	withLease.LeasePath = "/secrets/cert.lease"
+	cfg = &Config{Secrets: []*Secret{withLease}}
+	err = cfg.complete(&Secret{})
+	if err != nil {
+		t.Fatalf("error completing config: %v", err)
+	}
+	if cfg.TokenPath != "/secrets/cert.token" {
+		t.Errorf("token path should be /secrets/cert.token got: %v", cfg.TokenPath)
+	}
+
+	cfg = &Config{Secrets: []*Secret{cert()}}
+	err = cfg.complete(&Secret{})
+	if err == nil {
+		t.Errorf("expected error when no token path can be derived")
+	}
+}
+
+func TestRenewFraction(t *testing.T) {
+	cfg, err := FromSecret(&Secret{Path: "database/creds/foo", Template: "foo.tmpl"}, "")
+	if err != nil {
+		t.Fatalf("error building config: %v", err)
+	}
+	if cfg.Secrets[0].RenewFraction != defaultRenewFraction {
+		t.Errorf("expected default renew fraction, got: %v", cfg.Secrets[0].RenewFraction)
+	}
+
+	_, err = FromSecret(&Secret{Path: "database/creds/foo", Template: "foo.tmpl", RenewFraction: 0.99}, "")
+	if err != nil {
+		t.Errorf("renew fraction of 0.99 should be accepted: %v", err)
+	}
+
+	for _, fraction := range []float64{1, 1.5, -0.5} {
+		_, err = FromSecret(&Secret{Path: "database/creds/foo", Template: "foo.tmpl", RenewFraction: fraction}, "")
+		if err == nil {
+			t.Errorf("expected error for renew fraction %v", fraction)
+		}
+	}
+}
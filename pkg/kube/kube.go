@@ -26,7 +26,7 @@ func createClientSet(config *rest.Config) (*kubernetes.Clientset, error) {
 	return c, nil
 }
 
-func NewKubeChecker(pod, namespace string) (*KubeChecker, error) {
+func createInClusterClientSet() (*kubernetes.Clientset, error) {
 	config, err := rest.InClusterConfig()
 	if err != nil {
 		return nil, fmt.Errorf("error creating kube client config: %s", err)
@@ -36,6 +36,14 @@ func NewKubeChecker(pod, namespace string) (*KubeChecker, error) {
 	if err != nil {
 		return nil, fmt.Errorf("error creating kube client: %s", err)
 	}
+	return clientSet, nil
+}
+
+func NewKubeChecker(pod, namespace string) (*KubeChecker, error) {
+	clientSet, err := createInClusterClientSet()
+	if err != nil {
+		return nil, err
+	}
 	return &KubeChecker{client: clientSet, podName: pod, namespace: namespace}, nil
 }
 
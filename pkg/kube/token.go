@@ -0,0 +1,92 @@
+package kube
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	authv1 "k8s.io/api/authentication/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// TokenRequester mints service account tokens bound to an
+// audience using the TokenRequest API
+type TokenRequester struct {
+	client         kubernetes.Interface
+	namespace      string
+	serviceAccount string
+	audience       string
+	expiry         time.Duration
+}
+
+func NewTokenRequester(namespace, serviceAccount, audience string, expiry time.Duration) (*TokenRequester, error) {
+	clientSet, err := createInClusterClientSet()
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenRequester{client: clientSet, namespace: namespace, serviceAccount: serviceAccount, audience: audience, expiry: expiry}, nil
+}
+
+// Token requests a new token for the service account
+func (t *TokenRequester) Token() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	expirySeconds := int64(t.expiry.Seconds())
+	request := &authv1.TokenRequest{
+		Spec: authv1.TokenRequestSpec{
+			Audiences:         []string{t.audience},
+			ExpirationSeconds: &expirySeconds,
+		},
+	}
+
+	resp, err := t.client.CoreV1().ServiceAccounts(t.namespace).CreateToken(ctx, t.serviceAccount, request, v1.CreateOptions{})
+	if err != nil {
+		return "", fmt.Errorf("error requesting service account token: %s", err)
+	}
+
+	log.WithFields(log.Fields{"audience": t.audience, "expires": resp.Status.ExpirationTimestamp}).Infof("requested service account token")
+	return resp.Status.Token, nil
+}
+
+// ServiceAccountFromToken reads the namespace and name of the
+// service account a token was issued to from its subject claim
+func ServiceAccountFromToken(path string) (string, string, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", "", fmt.Errorf("error reading token: %s", err)
+	}
+
+	parts := strings.Split(strings.TrimSpace(string(bytes)), ".")
+	if len(parts) != 3 {
+		return "", "", fmt.Errorf("token is not a jwt")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", "", fmt.Errorf("error decoding token: %s", err)
+	}
+
+	var claims struct {
+		Subject string `json:"sub"`
+	}
+	err = json.Unmarshal(payload, &claims)
+	if err != nil {
+		return "", "", fmt.Errorf("error decoding token claims: %s", err)
+	}
+
+	// subjects are of the form system:serviceaccount:<namespace>:<name>
+	subject := strings.Split(claims.Subject, ":")
+	if len(subject) != 4 || subject[0] != "system" || subject[1] != "serviceaccount" {
+		return "", "", fmt.Errorf("token subject %s is not a service account", claims.Subject)
+	}
+
+	return subject[2], subject[3], nil
+}
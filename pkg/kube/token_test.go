@@ -0,0 +1,49 @@
+package kube
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	authv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestServiceAccountFromToken(t *testing.T) {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub": "system:serviceaccount:my-namespace:my-app"}`))
+	err := ioutil.WriteFile("/tmp/testsatoken", []byte("header."+payload+".signature\n"), 0600)
+	if err != nil {
+		t.Fatalf("error writing token: %v", err)
+	}
+
+	namespace, name, err := ServiceAccountFromToken("/tmp/testsatoken")
+	if err != nil {
+		t.Fatalf("error reading service account: %v", err)
+	}
+	if namespace != "my-namespace" || name != "my-app" {
+		t.Errorf("service account should be my-namespace/my-app got: %v/%v", namespace, name)
+	}
+}
+
+func TestTokenRequest(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "serviceaccounts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		request := action.(k8stesting.CreateAction).GetObject().(*authv1.TokenRequest)
+		if action.GetSubresource() != "token" || request.Spec.Audiences[0] != "vault" || *request.Spec.ExpirationSeconds != 600 {
+			t.Errorf("unexpected token request: %v", request.Spec)
+		}
+		return true, &authv1.TokenRequest{Status: authv1.TokenRequestStatus{Token: "audience-bound"}}, nil
+	})
+
+	requester := &TokenRequester{client: client, namespace: "my-namespace", serviceAccount: "my-app", audience: "vault", expiry: 10 * time.Minute}
+	token, err := requester.Token()
+	if err != nil {
+		t.Fatalf("error requesting token: %v", err)
+	}
+	if token != "audience-bound" {
+		t.Errorf("token should be audience-bound got: %v", token)
+	}
+}
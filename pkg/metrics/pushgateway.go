@@ -14,72 +14,150 @@ var (
 	podName       = os.Getenv("POD_NAME")
 	promNamespace = "vault_creds"
 
-	errorTime = prometheus.NewGauge(prometheus.GaugeOpts{
+	errorTime = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: promNamespace,
 		Name:      "credential_renewal_error_unix_timestamp",
 		Help:      "The unix timestamp of the last error during renewal of a secret",
-	})
+	}, []string{"secret"})
 
-	errorCount = prometheus.NewCounter(prometheus.CounterOpts{
+	errorCount = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Namespace: promNamespace,
 		Name:      "credential_renewal_errors_total",
 		Help:      "Number of errors when renewing credentials",
-	})
+	}, []string{"secret"})
 
-	successTime = prometheus.NewGauge(prometheus.GaugeOpts{
+	successTime = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: promNamespace,
 		Name:      "credential_renewal_success_unix_timestamp",
 		Help:      "The unix timestamp of the last successful renewal of a secret",
-	})
+	}, []string{"secret"})
 
-	leaseExpiration = prometheus.NewGauge(prometheus.GaugeOpts{
+	leaseExpiration = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: promNamespace,
 		Name:      "credential_expiry_time_seconds",
 		Help:      "The time remaining until the secret lease expires",
+	}, []string{"secret"})
+
+	tokenErrorTime = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: promNamespace,
+		Name:      "token_renewal_error_unix_timestamp",
+		Help:      "The unix timestamp of the last error during renewal of the Vault token",
 	})
+
+	tokenErrorCount = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: promNamespace,
+		Name:      "token_renewal_errors_total",
+		Help:      "Number of errors when renewing the Vault token",
+	})
+
+	tokenSuccessTime = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: promNamespace,
+		Name:      "token_renewal_success_unix_timestamp",
+		Help:      "The unix timestamp of the last successful renewal of the Vault token",
+	})
+
+	tokenExpiration = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: promNamespace,
+		Name:      "token_expiry_time_seconds",
+		Help:      "The time remaining until the Vault token expires",
+	})
+
+	endpoint = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: promNamespace,
+		Name:      "vault_endpoint",
+		Help:      "Set to 1 for the Vault address currently in use",
+	}, []string{"address"})
 )
 
+// PushGateway pushes metrics to a Prometheus Pushgateway. Pushers
+// aren't safe for concurrent use, so every goroutine that pushes
+// needs its own gateway from Copy or WithSecret.
 type PushGateway struct {
-	Pusher  *push.Pusher
-	address string
+	Pusher   *push.Pusher
+	registry *prometheus.Registry
+	address  string
+	// secret is empty for unlabelled metrics, Prometheus treats a
+	// label with no value the same as a missing label
+	secret string
 }
 
 func NewPushGateway(gatewayAddress string) *PushGateway {
 	registry := prometheus.NewRegistry()
 	registry.MustRegister(leaseExpiration, errorTime, successTime, errorCount)
-
-	pusher := push.New(gatewayAddress, "vault-creds").Gatherer(registry)
+	registry.MustRegister(tokenExpiration, tokenErrorTime, tokenSuccessTime, tokenErrorCount)
+	registry.MustRegister(endpoint)
 
 	return &PushGateway{
-		Pusher:  pusher,
-		address: gatewayAddress,
+		Pusher:   newPusher(gatewayAddress, registry),
+		registry: registry,
+		address:  gatewayAddress,
 	}
 
 }
 
+// newPusher creates a pusher for the pod's metrics, the grouping is
+// set once here as setting it modifies the pusher
+func newPusher(address string, registry *prometheus.Registry) *push.Pusher {
+	return push.New(address, "vault-creds").
+		Gatherer(registry).
+		Grouping("instance", podName).
+		Grouping("namespace", namespace).
+		Grouping("pod", podName)
+}
+
+// Copy returns a gateway with its own pusher
+func (p *PushGateway) Copy() *PushGateway {
+	return &PushGateway{Pusher: newPusher(p.address, p.registry), registry: p.registry, address: p.address, secret: p.secret}
+}
+
+// WithSecret returns a gateway with its own pusher that labels its
+// metrics with the name of the secret being renewed
+func (p *PushGateway) WithSecret(name string) *PushGateway {
+	gateway := p.Copy()
+	gateway.secret = name
+	return gateway
+}
+
 func (p *PushGateway) SetExpiration(newLeaseDiff time.Duration) {
-	leaseExpiration.Set(float64(newLeaseDiff.Seconds()))
+	leaseExpiration.WithLabelValues(p.secret).Set(float64(newLeaseDiff.Seconds()))
 }
 
 func (p *PushGateway) SetSuccessTime() {
-	successTime.SetToCurrentTime()
+	successTime.WithLabelValues(p.secret).SetToCurrentTime()
 }
 
 func (p *PushGateway) SetFailureTime() {
-	errorTime.SetToCurrentTime()
+	errorTime.WithLabelValues(p.secret).SetToCurrentTime()
 }
 
 func (p *PushGateway) SetFailureCount() {
-	errorCount.Add(1)
+	errorCount.WithLabelValues(p.secret).Add(1)
+}
+
+func (p *PushGateway) SetTokenExpiration(newTokenDiff time.Duration) {
+	tokenExpiration.Set(float64(newTokenDiff.Seconds()))
+}
+
+func (p *PushGateway) SetTokenSuccessTime() {
+	tokenSuccessTime.SetToCurrentTime()
+}
+
+func (p *PushGateway) SetTokenFailureTime() {
+	tokenErrorTime.SetToCurrentTime()
+}
+
+func (p *PushGateway) SetTokenFailureCount() {
+	tokenErrorCount.Add(1)
+}
+
+func (p *PushGateway) SetEndpoint(address string) {
+	endpoint.Reset()
+	endpoint.WithLabelValues(address).Set(1)
 }
 
 func (p *PushGateway) Push() {
 	if p.address != "" {
-		err := p.Pusher.
-			Grouping("instance", podName).
-			Grouping("namespace", namespace).
-			Grouping("pod", podName).
-			Add()
+		err := p.Pusher.Add()
 		if err != nil {
 			log.Errorf("Could not push to Pushgateway: %s", err)
 		}
@@ -0,0 +1,48 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentPush(t *testing.T) {
+	var mu sync.Mutex
+	paths := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths[r.URL.Path]++
+		mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	gateway := NewPushGateway(server.URL)
+	gateways := []*PushGateway{gateway.Copy(), gateway.WithSecret("a"), gateway.WithSecret("b")}
+
+	var wg sync.WaitGroup
+	for _, g := range gateways {
+		wg.Add(1)
+		go func(g *PushGateway) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				g.SetSuccessTime()
+				g.Push()
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	total := 0
+	for path, count := range paths {
+		if !strings.HasPrefix(path, "/metrics/job/vault-creds/") || !strings.Contains(path, "/pod") {
+			t.Errorf("unexpected push path %s", path)
+		}
+		total += count
+	}
+	if total != 60 {
+		t.Errorf("expected 60 pushes got: %v", total)
+	}
+}
@@ -0,0 +1,95 @@
+package vault
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// sinkPollInterval is how often an agent sink is checked
+// for a rotated token
+var sinkPollInterval = 5 * time.Second
+
+// AgentVaultClientFactory creates a Vault client using the token
+// Vault Agent's auto-auth writes to a file sink. The agent owns the
+// token so it is never renewed or revoked, instead the sink is
+// watched for the agent replacing it.
+type AgentVaultClientFactory struct {
+	vault *VaultConfig
+	agent *AgentAuthConfig
+}
+
+func NewAgentAuthClientFactory(vault *VaultConfig, agent *AgentAuthConfig) ClientFactory {
+	return &AgentVaultClientFactory{vault: vault, agent: agent}
+}
+
+// Create returns a Vault client using the agent's token
+func (f *AgentVaultClientFactory) Create() (*AuthClient, error) {
+	auth, err := createAuthClient(f.vault, f)
+	if err != nil {
+		return nil, err
+	}
+	auth.external = true
+	auth.sink = f.agent.SinkFile
+
+	return auth, nil
+}
+
+// authenticate reads the current token from the sink
+func (f *AgentVaultClientFactory) authenticate(client *api.Client) (*api.Secret, error) {
+	token, err := readValue("", f.agent.SinkFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading agent sink: %s", err)
+	}
+	if token == "" {
+		return nil, fmt.Errorf("agent sink %s is empty", f.agent.SinkFile)
+	}
+
+	client.SetToken(token)
+	secret, err := lookupSelf(client, f.vault.AuthNamespace)
+	if err != nil {
+		return nil, err
+	}
+
+	log.WithFields(secretFields(secret)).Infof("using vault agent token")
+	return secret, nil
+}
+
+// lookupSelf returns the client's token as an auth secret with its
+// remaining TTL as the lease duration
+func lookupSelf(client *api.Client, namespace string) (*api.Secret, error) {
+	req := newNamespacedRequest(client, namespace, "GET", "/v1/auth/token/lookup-self")
+	resp, err := client.RawRequest(req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error looking up token: %w", err)
+	}
+
+	secret, err := api.ParseSecret(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing token: %s", err)
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, fmt.Errorf("token lookup returned no data")
+	}
+
+	ttl, err := jsonInt(secret.Data["ttl"])
+	if err != nil {
+		return nil, fmt.Errorf("error parsing token ttl: %s", err)
+	}
+	renewable, _ := secret.Data["renewable"].(bool)
+	accessor, _ := secret.Data["accessor"].(string)
+
+	secret.Auth = &api.SecretAuth{
+		ClientToken:   client.Token(),
+		Accessor:      accessor,
+		LeaseDuration: ttl,
+		Renewable:     renewable,
+	}
+
+	return secret, nil
+}
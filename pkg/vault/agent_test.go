@@ -0,0 +1,77 @@
+package vault
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/uswitch/vault-creds/pkg/metrics"
+)
+
+func TestAgentSink(t *testing.T) {
+	dir := t.TempDir()
+	socket := filepath.Join(dir, "agent.sock")
+	sink := filepath.Join(dir, "token")
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("error listening on socket: %v", err)
+	}
+	revoked := false
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth/token/lookup-self":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"data": map[string]interface{}{"ttl": 300, "renewable": true, "accessor": "accessor-" + r.Header.Get("X-Vault-Token")},
+			})
+		case "/v1/auth/token/revoke-self":
+			revoked = true
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	err = ioutil.WriteFile(sink, []byte("first\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	factory := NewAgentAuthClientFactory(&VaultConfig{VaultAddr: "unix://" + socket, TLS: &TLSConfig{}}, &AgentAuthConfig{SinkFile: sink})
+	auth, err := factory.Create()
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+	if auth.Client.Token() != "first" || auth.TokenDuration().Seconds() != 300 {
+		t.Errorf("expected token first with a 300s ttl, got %s %s", auth.Client.Token(), auth.TokenDuration())
+	}
+
+	manager := NewTokenManager(auth, metrics.NewPushGateway(""), TokenManagerConfig{})
+	err = manager.checkSink()
+	if err != nil || auth.Logins() != 0 {
+		t.Errorf("expected unchanged sink to be ignored: %v", err)
+	}
+
+	err = ioutil.WriteFile(sink, []byte("second\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = manager.checkSink()
+	if err != nil {
+		t.Errorf("error reading rotated token: %v", err)
+	}
+	if auth.Client.Token() != "second" || auth.Logins() != 1 {
+		t.Errorf("expected rotated token second, got %s", auth.Client.Token())
+	}
+
+	auth.RevokeSelf()
+	if revoked {
+		t.Errorf("agent token should not be revoked")
+	}
+}
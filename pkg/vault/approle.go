@@ -0,0 +1,147 @@
+package vault
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// AppRoleVaultClientFactory creates a Vault client
+// authenticated with an AppRole role and secret ID
+type AppRoleVaultClientFactory struct {
+	vault   *VaultConfig
+	approle *AppRoleAuthConfig
+
+	mu sync.Mutex
+	// secretID holds a secret ID once it has been unwrapped, the
+	// wrapping token can only be used once
+	secretID string
+}
+
+type appRoleLogin struct {
+	RoleID   string `json:"role_id"`
+	SecretID string `json:"secret_id,omitempty"`
+}
+
+func NewAppRoleAuthClientFactory(vault *VaultConfig, approle *AppRoleAuthConfig) ClientFactory {
+	return &AppRoleVaultClientFactory{vault: vault, approle: approle}
+}
+
+// Create returns a Vault client that has been authenticated
+// with the AppRole credentials
+func (f *AppRoleVaultClientFactory) Create() (*AuthClient, error) {
+	return createAuthClient(f.vault, f)
+}
+
+func (f *AppRoleVaultClientFactory) authenticate(client *api.Client) (*api.Secret, error) {
+	roleID, err := readValue(f.approle.RoleID, f.approle.RoleIDFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading role id: %s", err)
+	}
+	if roleID == "" {
+		return nil, fmt.Errorf("no role id supplied")
+	}
+
+	secretID, err := f.readSecretID(client)
+	if err != nil {
+		return nil, err
+	}
+
+	return loginWith(client, f.vault.AuthNamespace, f.approle.LoginPath, &appRoleLogin{RoleID: roleID, SecretID: secretID})
+}
+
+func (f *AppRoleVaultClientFactory) readSecretID(client *api.Client) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.secretID != "" {
+		return f.secretID, nil
+	}
+
+	secretID, err := readValue(f.approle.SecretID, f.approle.SecretIDFile)
+	if err != nil {
+		return "", fmt.Errorf("error reading secret id: %s", err)
+	}
+
+	if !f.approle.SecretIDWrapped || secretID == "" {
+		return secretID, nil
+	}
+
+	// the wrapping token has already been used if another process,
+	// such as the init container, unwrapped the secret id
+	if f.approle.UnwrappedSecretIDFile != "" {
+		unwrapped, err := readValue("", f.approle.UnwrappedSecretIDFile)
+		if err == nil && unwrapped != "" {
+			log.Infof("using secret id unwrapped to %s", f.approle.UnwrappedSecretIDFile)
+			f.secretID = unwrapped
+			return unwrapped, nil
+		}
+	}
+
+	secretID, err = unwrapSecretID(client, f.vault.AuthNamespace, secretID)
+	if err != nil {
+		return "", err
+	}
+	f.secretID = secretID
+
+	if f.approle.UnwrappedSecretIDFile != "" {
+		err = ioutil.WriteFile(f.approle.UnwrappedSecretIDFile, []byte(secretID), 0600)
+		if err != nil {
+			log.Errorf("error saving unwrapped secret id, a restored token can't be replaced: %s", err)
+		} else {
+			log.Infof("wrote unwrapped secret id to %s", f.approle.UnwrappedSecretIDFile)
+		}
+	}
+
+	return secretID, nil
+}
+
+// unwrapSecretID exchanges a response wrapping token for the
+// secret ID it wraps. Secret IDs are wrapped in the namespace of
+// the approle, so the token is unwrapped there too.
+func unwrapSecretID(client *api.Client, namespace, wrappingToken string) (string, error) {
+	req := newNamespacedRequest(client, namespace, "PUT", "/v1/sys/wrapping/unwrap")
+	req.ClientToken = wrappingToken
+	resp, err := client.RawRequest(req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return "", fmt.Errorf("error unwrapping secret id: %s", err)
+	}
+
+	secret, err := api.ParseSecret(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("error parsing unwrapped secret id: %s", err)
+	}
+	if secret == nil {
+		return "", fmt.Errorf("wrapped response was empty")
+	}
+
+	secretID, ok := secret.Data["secret_id"].(string)
+	if !ok || secretID == "" {
+		return "", fmt.Errorf("wrapped response did not contain a secret id")
+	}
+
+	log.Infof("unwrapped secret id")
+	return secretID, nil
+}
+
+// readValue returns the contents of file if one is given,
+// otherwise value
+func readValue(value, file string) (string, error) {
+	if file == "" {
+		return value, nil
+	}
+
+	bytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(bytes)), nil
+}
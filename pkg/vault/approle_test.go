@@ -0,0 +1,120 @@
+package vault
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+// newAppRoleServer fakes an approle auth method whose secret id is
+// wrapped in wrapping-token, which can be unwrapped once
+func newAppRoleServer(unwraps *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/sys/wrapping/unwrap":
+			*unwraps++
+			if r.Header.Get("X-Vault-Token") != "wrapping-token" || r.Header.Get("X-Vault-Namespace") != "auth" || *unwraps > 1 {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`{"data": {"secret_id": "unwrapped-secret"}}`))
+		case "/v1/auth/approle/login":
+			var body appRoleLogin
+			json.NewDecoder(r.Body).Decode(&body)
+			if body.RoleID != "my-role" || body.SecretID != "unwrapped-secret" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`{"auth": {"client_token": "approle-token", "lease_duration": 3600, "renewable": true}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestAppRoleWrappedSecretID(t *testing.T) {
+	unwraps := 0
+	server := newAppRoleServer(&unwraps)
+	defer server.Close()
+
+	err := ioutil.WriteFile("/tmp/testroleid", []byte("my-role\n"), 0600)
+	if err != nil {
+		t.Fatalf("error writing role id: %v", err)
+	}
+
+	factory := NewAppRoleAuthClientFactory(
+		&VaultConfig{VaultAddr: server.URL, Namespace: "secrets", AuthNamespace: "auth", TLS: &TLSConfig{}},
+		&AppRoleAuthConfig{LoginPath: "approle/login", RoleIDFile: "/tmp/testroleid", SecretID: "wrapping-token", SecretIDWrapped: true},
+	)
+
+	auth, err := factory.Create()
+	if err != nil {
+		t.Fatalf("error logging in: %v", err)
+	}
+	if auth.Client.Token() != "approle-token" {
+		t.Errorf("token should be approle-token got: %v", auth.Client.Token())
+	}
+
+	// logging in again must reuse the unwrapped secret id
+	auth.Client.SetToken("expired")
+	err = auth.Reauthenticate("expired")
+	if err != nil {
+		t.Errorf("error logging in again: %v", err)
+	}
+	if unwraps != 1 {
+		t.Errorf("secret id should be unwrapped once got: %v", unwraps)
+	}
+}
+
+func TestAppRoleRestoredWrappedSecretID(t *testing.T) {
+	unwraps := 0
+	server := newAppRoleServer(&unwraps)
+	defer server.Close()
+
+	// the init container and the sidecar are given the same
+	// wrapping token
+	vaultConfig := &VaultConfig{VaultAddr: server.URL, AuthNamespace: "auth", TLS: &TLSConfig{}}
+	approle := &AppRoleAuthConfig{
+		LoginPath:             "approle/login",
+		RoleID:                "my-role",
+		SecretID:              "wrapping-token",
+		SecretIDWrapped:       true,
+		UnwrappedSecretIDFile: filepath.Join(t.TempDir(), "token.secret-id"),
+	}
+
+	_, err := NewAppRoleAuthClientFactory(vaultConfig, approle).Create()
+	if err != nil {
+		t.Fatalf("error logging in: %v", err)
+	}
+
+	auth, err := NewAppRoleAuthClientFactory(vaultConfig, approle).Create()
+	if err != nil {
+		t.Fatalf("error logging in with the spent wrapping token: %v", err)
+	}
+	if auth.Client.Token() != "approle-token" {
+		t.Errorf("token should be approle-token got: %v", auth.Client.Token())
+	}
+	if unwraps != 1 {
+		t.Errorf("secret id should be unwrapped once got: %v", unwraps)
+	}
+}
+
+func TestAppRoleEmptyUnwrap(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	client, err := createUnauthenticatedClient(&VaultConfig{VaultAddr: server.URL, TLS: &TLSConfig{}})
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+
+	_, err = unwrapSecretID(client, "", "wrapping-token")
+	if err == nil {
+		t.Errorf("expected an error unwrapping an empty response")
+	}
+}
@@ -0,0 +1,93 @@
+package vault
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/uswitch/vault-creds/pkg/aws"
+)
+
+const getCallerIdentityBody = "Action=GetCallerIdentity&Version=2011-06-15"
+
+// AWSVaultClientFactory creates a Vault client authenticated
+// with the aws auth method's iam login, using a signed
+// sts:GetCallerIdentity request to prove the caller's identity
+type AWSVaultClientFactory struct {
+	vault *VaultConfig
+	iam   *AWSAuthConfig
+}
+
+type awsLogin struct {
+	Role    string `json:"role,omitempty"`
+	Method  string `json:"iam_http_request_method"`
+	URL     string `json:"iam_request_url"`
+	Body    string `json:"iam_request_body"`
+	Headers string `json:"iam_request_headers"`
+}
+
+func NewAWSAuthClientFactory(vault *VaultConfig, iam *AWSAuthConfig) ClientFactory {
+	return &AWSVaultClientFactory{vault: vault, iam: iam}
+}
+
+// Create returns a Vault client that has been authenticated
+// with the AWS credentials
+func (f *AWSVaultClientFactory) Create() (*AuthClient, error) {
+	return createAuthClient(f.vault, f)
+}
+
+// credentials are loaded and the request signed again on every
+// login as instance and task credentials are temporary
+func (f *AWSVaultClientFactory) authenticate(client *api.Client) (*api.Secret, error) {
+	creds, err := aws.LoadCredentials()
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := newAWSLogin(creds, f.iam, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	return loginWith(client, f.vault.AuthNamespace, f.iam.LoginPath, body)
+}
+
+// newAWSLogin signs a GetCallerIdentity request which Vault sends
+// on to STS to find the IAM principal logging in
+func newAWSLogin(creds *aws.Credentials, config *AWSAuthConfig, now time.Time) (*awsLogin, error) {
+	region := config.Region
+	endpoint := "https://sts.amazonaws.com/"
+	if region == "" {
+		region = "us-east-1"
+	} else {
+		endpoint = fmt.Sprintf("https://sts.%s.amazonaws.com/", region)
+	}
+
+	req, err := http.NewRequest("POST", endpoint, strings.NewReader(getCallerIdentityBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	if config.ServerID != "" {
+		req.Header.Set("X-Vault-AWS-IAM-Server-ID", config.ServerID)
+	}
+
+	aws.Sign(req, []byte(getCallerIdentityBody), creds, region, "sts", now)
+
+	headers, err := json.Marshal(req.Header)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling headers: %s", err)
+	}
+
+	return &awsLogin{
+		Role:    config.Role,
+		Method:  req.Method,
+		URL:     base64.StdEncoding.EncodeToString([]byte(endpoint)),
+		Body:    base64.StdEncoding.EncodeToString([]byte(getCallerIdentityBody)),
+		Headers: base64.StdEncoding.EncodeToString(headers),
+	}, nil
+}
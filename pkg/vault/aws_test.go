@@ -0,0 +1,68 @@
+package vault
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAWSLogin(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/auth/aws/login" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var body awsLogin
+		json.NewDecoder(r.Body).Decode(&body)
+
+		requestURL, _ := base64.StdEncoding.DecodeString(body.URL)
+		requestBody, _ := base64.StdEncoding.DecodeString(body.Body)
+		rawHeaders, _ := base64.StdEncoding.DecodeString(body.Headers)
+		var headers http.Header
+		json.Unmarshal(rawHeaders, &headers)
+
+		if body.Role != "worker" || body.Method != "POST" || string(requestURL) != "https://sts.eu-west-1.amazonaws.com/" || string(requestBody) != getCallerIdentityBody {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		authorization := headers.Get("Authorization")
+		if headers.Get("X-Vault-AWS-IAM-Server-ID") != "vault.example.com" || !strings.Contains(authorization, "x-vault-aws-iam-server-id") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		keyID := strings.SplitN(strings.TrimPrefix(authorization, "AWS4-HMAC-SHA256 Credential="), "/", 2)[0]
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"auth": map[string]interface{}{"client_token": "token-for-" + keyID, "lease_duration": 3600},
+		})
+	}))
+	defer server.Close()
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "FIRST")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	factory := NewAWSAuthClientFactory(
+		&VaultConfig{VaultAddr: server.URL, TLS: &TLSConfig{}},
+		&AWSAuthConfig{LoginPath: "aws/login", Role: "worker", Region: "eu-west-1", ServerID: "vault.example.com"},
+	)
+
+	auth, err := factory.Create()
+	if err != nil {
+		t.Fatalf("error logging in: %v", err)
+	}
+	if auth.Client.Token() != "token-for-FIRST" {
+		t.Errorf("token should be token-for-FIRST got: %v", auth.Client.Token())
+	}
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "SECOND")
+	err = auth.Reauthenticate("token-for-FIRST")
+	if err != nil {
+		t.Errorf("error logging in again: %v", err)
+	}
+	if auth.Client.Token() != "token-for-SECOND" {
+		t.Errorf("token should be token-for-SECOND got: %v", auth.Client.Token())
+	}
+}
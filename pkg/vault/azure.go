@@ -0,0 +1,110 @@
+package vault
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/hashicorp/vault/api"
+)
+
+const (
+	defaultAzureMetadataAddr = "http://169.254.169.254"
+	defaultAzureResource     = "https://management.azure.com/"
+)
+
+// AzureVaultClientFactory creates a Vault client authenticated
+// with the azure auth method, using a managed identity token
+// and the instance's metadata
+type AzureVaultClientFactory struct {
+	vault *VaultConfig
+	azure *AzureAuthConfig
+}
+
+type azureLogin struct {
+	Role              string `json:"role"`
+	JWT               string `json:"jwt"`
+	SubscriptionID    string `json:"subscription_id"`
+	ResourceGroupName string `json:"resource_group_name"`
+	VMName            string `json:"vm_name,omitempty"`
+	VMSSName          string `json:"vmss_name,omitempty"`
+}
+
+type azureToken struct {
+	AccessToken string `json:"access_token"`
+}
+
+type azureInstance struct {
+	Compute struct {
+		Name              string `json:"name"`
+		ResourceGroupName string `json:"resourceGroupName"`
+		SubscriptionID    string `json:"subscriptionId"`
+		VMScaleSetName    string `json:"vmScaleSetName"`
+	} `json:"compute"`
+}
+
+func NewAzureAuthClientFactory(vault *VaultConfig, azure *AzureAuthConfig) ClientFactory {
+	return &AzureVaultClientFactory{vault: vault, azure: azure}
+}
+
+// Create returns a Vault client that has been authenticated
+// with the managed identity
+func (f *AzureVaultClientFactory) Create() (*AuthClient, error) {
+	return createAuthClient(f.vault, f)
+}
+
+func (f *AzureVaultClientFactory) authenticate(client *api.Client) (*api.Secret, error) {
+	addr := f.azure.MetadataAddr
+	if addr == "" {
+		addr = defaultAzureMetadataAddr
+	}
+	header := http.Header{"Metadata": []string{"true"}}
+
+	resource := f.azure.Resource
+	if resource == "" {
+		resource = defaultAzureResource
+	}
+	params := url.Values{}
+	params.Set("api-version", "2018-02-01")
+	params.Set("resource", resource)
+	if f.azure.ClientID != "" {
+		params.Set("client_id", f.azure.ClientID)
+	}
+
+	body, err := getMetadata(addr, "/metadata/identity/oauth2/token", params, header)
+	if err != nil {
+		return nil, fmt.Errorf("error requesting managed identity token: %s", err)
+	}
+	var token azureToken
+	err = json.Unmarshal(body, &token)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing managed identity token: %s", err)
+	}
+
+	body, err = getMetadata(addr, "/metadata/instance", url.Values{"api-version": []string{"2017-08-01"}}, header)
+	if err != nil {
+		return nil, fmt.Errorf("error requesting instance metadata: %s", err)
+	}
+	var instance azureInstance
+	err = json.Unmarshal(body, &instance)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing instance metadata: %s", err)
+	}
+
+	// scale set instances log in with the scale set name rather
+	// than the generated instance name
+	l := &azureLogin{
+		Role:              f.azure.Role,
+		JWT:               token.AccessToken,
+		SubscriptionID:    instance.Compute.SubscriptionID,
+		ResourceGroupName: instance.Compute.ResourceGroupName,
+	}
+	if instance.Compute.VMScaleSetName != "" {
+		l.VMSSName = instance.Compute.VMScaleSetName
+	} else {
+		l.VMName = instance.Compute.Name
+	}
+
+	return loginWith(client, f.vault.AuthNamespace, f.azure.LoginPath, l)
+}
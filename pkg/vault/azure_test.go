@@ -0,0 +1,60 @@
+package vault
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAzureLogin(t *testing.T) {
+	metadata := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata") != "true" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch r.URL.Path {
+		case "/metadata/identity/oauth2/token":
+			if r.URL.Query().Get("resource") != "https://vault.example.com" || r.URL.Query().Get("client_id") != "identity" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`{"access_token": "managed-identity-jwt"}`))
+		case "/metadata/instance":
+			w.Write([]byte(`{"compute": {"name": "aks-nodes_0", "resourceGroupName": "nodes", "subscriptionId": "sub", "vmScaleSetName": "aks-nodes"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer metadata.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body azureLogin
+		json.NewDecoder(r.Body).Decode(&body)
+		if r.URL.Path != "/v1/auth/azure/login" || body.Role != "web" || body.JWT != "managed-identity-jwt" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if body.SubscriptionID != "sub" || body.ResourceGroupName != "nodes" || body.VMSSName != "aks-nodes" || body.VMName != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"auth": map[string]interface{}{"client_token": "azure-token", "lease_duration": 3600},
+		})
+	}))
+	defer server.Close()
+
+	factory := NewAzureAuthClientFactory(
+		&VaultConfig{VaultAddr: server.URL, TLS: &TLSConfig{}},
+		&AzureAuthConfig{LoginPath: "azure/login", Role: "web", Resource: "https://vault.example.com", ClientID: "identity", MetadataAddr: metadata.URL},
+	)
+
+	auth, err := factory.Create()
+	if err != nil {
+		t.Fatalf("error logging in: %v", err)
+	}
+	if auth.Client.Token() != "azure-token" {
+		t.Errorf("token should be azure-token got: %v", auth.Client.Token())
+	}
+}
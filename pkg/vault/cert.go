@@ -0,0 +1,40 @@
+package vault
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// CertVaultClientFactory creates a Vault client authenticated
+// with the cert auth method, using the client certificate
+// presented on the TLS connection
+type CertVaultClientFactory struct {
+	vault *VaultConfig
+	cert  *CertAuthConfig
+}
+
+type certLogin struct {
+	Name string `json:"name,omitempty"`
+}
+
+func NewCertAuthClientFactory(vault *VaultConfig, cert *CertAuthConfig) ClientFactory {
+	return &CertVaultClientFactory{vault: vault, cert: cert}
+}
+
+// Create returns a Vault client that has been authenticated
+// with the client certificate
+func (f *CertVaultClientFactory) Create() (*AuthClient, error) {
+	if f.vault.TLS == nil || f.vault.TLS.ClientCert == "" {
+		return nil, fmt.Errorf("a client certificate is required for cert authentication")
+	}
+
+	return createAuthClient(f.vault, f)
+}
+
+// the certificate is sent during the TLS handshake, so the
+// login only names the role to authenticate against. Vault
+// tries every role if none is given.
+func (f *CertVaultClientFactory) authenticate(client *api.Client) (*api.Secret, error) {
+	return loginWith(client, f.vault.AuthNamespace, f.cert.LoginPath, &certLogin{Name: f.cert.Role})
+}
@@ -0,0 +1,64 @@
+package vault
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCertLogin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault-cert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/auth/cert/login" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var body certLogin
+		json.NewDecoder(r.Body).Decode(&body)
+		if body.Name != "web" || len(r.TLS.PeerCertificates) == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"auth": map[string]interface{}{"client_token": "token-for-" + r.TLS.PeerCertificates[0].Subject.CommonName, "lease_duration": 3600},
+		})
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	caPath := filepath.Join(dir, "ca.crt")
+	writePEM(t, caPath, "CERTIFICATE", server.Certificate().Raw)
+	certPath, keyPath := writeCertificate(t, dir, "host1")
+
+	factory := NewCertAuthClientFactory(
+		&VaultConfig{VaultAddr: server.URL, TLS: &TLSConfig{CACert: caPath}},
+		&CertAuthConfig{LoginPath: "cert/login", Role: "web"},
+	)
+	_, err = factory.Create()
+	if err == nil {
+		t.Errorf("expected error logging in without a client certificate")
+	}
+
+	factory = NewCertAuthClientFactory(
+		&VaultConfig{VaultAddr: server.URL, TLS: &TLSConfig{CACert: caPath, ClientCert: certPath, ClientKey: keyPath}},
+		&CertAuthConfig{LoginPath: "cert/login", Role: "web"},
+	)
+	auth, err := factory.Create()
+	if err != nil {
+		t.Fatalf("error logging in: %v", err)
+	}
+	if auth.Client.Token() != "token-for-host1" {
+		t.Errorf("token should be token-for-host1 got: %v", auth.Client.Token())
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	yaml "gopkg.in/yaml.v1"
@@ -35,6 +37,17 @@ func (c *Certificate) Save(path string) error {
 	return nil
 }
 
+// Validity returns when the certificate became valid and when it
+// expires. The start is zero if the certificate can't be parsed, for
+// example when it was issued in DER format.
+func (c *Certificate) Validity() (time.Time, time.Time) {
+	certs, err := parsePEMCertificates(c.Certificate)
+	if err != nil || len(certs) == 0 {
+		return time.Time{}, time.Unix(c.Expiration, 0)
+	}
+	return certs[0].NotBefore, certs[0].NotAfter
+}
+
 func (c *Certificate) EnvVars() map[string]string {
 	envMap := make(map[string]string)
 
@@ -45,6 +58,113 @@ func (c *Certificate) EnvVars() map[string]string {
 
 	envMap["Certificate"] = c.Certificate
 	envMap["PrivateKey"] = c.PrivateKey
+	envMap["IssuingCA"] = c.IssuingCA
+	envMap["CAChain"] = strings.Join(c.CAChain, "\n")
 
 	return envMap
 }
+
+// Parts of a certificate that can be written to their own file
+const (
+	CertificateContents = "certificate"
+	PrivateKeyContents  = "private-key"
+	CAContents          = "ca"
+	FullChainContents   = "fullchain"
+)
+
+// CertificateFile is a file holding part of a certificate, such as
+// tls.crt or tls.key in a Kubernetes TLS secret
+type CertificateFile struct {
+	Path     string      `yaml:"path"`
+	Contents string      `yaml:"contents"`
+	Mode     os.FileMode `yaml:"mode"`
+}
+
+// FileMode returns the configured mode, by default private keys
+// are only readable by their owner
+func (f *CertificateFile) FileMode() os.FileMode {
+	if f.Mode != 0 {
+		return f.Mode
+	}
+	if f.Contents == PrivateKeyContents {
+		return 0600
+	}
+	return 0644
+}
+
+// pem returns the PEM encoded contents of file f
+func (c *Certificate) pem(f *CertificateFile) (string, error) {
+	var blocks []string
+	switch f.Contents {
+	case CertificateContents:
+		blocks = []string{c.Certificate}
+	case PrivateKeyContents:
+		blocks = []string{c.PrivateKey}
+	case CAContents:
+		blocks = []string{c.IssuingCA}
+	case FullChainContents:
+		blocks = append([]string{c.Certificate}, c.CAChain...)
+	default:
+		return "", fmt.Errorf("unknown certificate file contents %s", f.Contents)
+	}
+
+	var b strings.Builder
+	for _, block := range blocks {
+		block = strings.TrimSpace(block)
+		if block != "" {
+			b.WriteString(block + "\n")
+		}
+	}
+	return b.String(), nil
+}
+
+// WriteFiles writes each part of the certificate to its file
+func (c *Certificate) WriteFiles(files []*CertificateFile) error {
+	for _, f := range files {
+		contents, err := c.pem(f)
+		if err != nil {
+			return err
+		}
+
+		err = writeFile(f.Path, []byte(contents), f.FileMode())
+		if err != nil {
+			return fmt.Errorf("error writing %s: %v", f.Path, err)
+		}
+
+		log.Printf("wrote %s to %s", f.Contents, f.Path)
+	}
+
+	return nil
+}
+
+// writeFile replaces path with contents, so readers never see a
+// partially written certificate, and sets its mode
+func writeFile(path string, contents []byte, mode os.FileMode) error {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(contents)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Chmod(mode)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
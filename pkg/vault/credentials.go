@@ -5,6 +5,9 @@ import (
 	"io/ioutil"
 	"os"
 	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/api"
 
 	log "github.com/sirupsen/logrus"
 	yaml "gopkg.in/yaml.v1"
@@ -49,3 +52,27 @@ func (c *Credentials) EnvVars() map[string]string {
 
 	return envMap
 }
+
+func (c *Credentials) Lease() *api.Secret {
+	return c.Secret
+}
+
+func (c *Credentials) ExpireTime() (time.Time, error) {
+	return parseExpireTime(c.LeaseExpireTime)
+}
+
+func (c *Credentials) SetExpireTime(expire time.Time) {
+	c.LeaseExpireTime = formatExpireTime(expire)
+}
+
+func parseExpireTime(expire *string) (time.Time, error) {
+	if expire == nil {
+		return time.Time{}, fmt.Errorf("lease has no expiry time")
+	}
+	return time.Parse(time.RFC3339, *expire)
+}
+
+func formatExpireTime(expire time.Time) *string {
+	formatted := expire.Format(time.RFC3339)
+	return &formatted
+}
@@ -0,0 +1,100 @@
+package vault
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+)
+
+// generateKey creates the private key for a certificate signed
+// from a CSR. bits is the RSA key size or the EC curve size, zero
+// picks the same default as Vault.
+func generateKey(keyType string, bits int) (crypto.Signer, error) {
+	switch keyType {
+	case "rsa":
+		if bits == 0 {
+			bits = 2048
+		}
+		return rsa.GenerateKey(rand.Reader, bits)
+	case "ec":
+		var curve elliptic.Curve
+		switch bits {
+		case 224:
+			curve = elliptic.P224()
+		case 0, 256:
+			curve = elliptic.P256()
+		case 384:
+			curve = elliptic.P384()
+		case 521:
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported ec key bits %d", bits)
+		}
+		return ecdsa.GenerateKey(curve, rand.Reader)
+	case "ed25519":
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	}
+
+	return nil, fmt.Errorf("unknown key type %s", keyType)
+}
+
+// newCSR generates a key and a PEM encoded certificate signing
+// request for commonName. Other names are sent to Vault as
+// parameters rather than in the request.
+func newCSR(keyType string, bits int, commonName string) (crypto.Signer, string, error) {
+	key, err := generateKey(keyType, bits)
+	if err != nil {
+		return nil, "", fmt.Errorf("error generating key: %v", err)
+	}
+
+	template := &x509.CertificateRequest{Subject: pkix.Name{CommonName: commonName}}
+	der, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	if err != nil {
+		return nil, "", fmt.Errorf("error creating csr: %v", err)
+	}
+
+	return key, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})), nil
+}
+
+// encodePrivateKey encodes key the same way Vault would have for
+// the requested format and private_key_format
+func encodePrivateKey(key crypto.Signer, format, keyFormat string) (string, error) {
+	var blockType string
+	var der []byte
+	var err error
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if keyFormat != "pkcs8" {
+			blockType, der = "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(k)
+		}
+	case *ecdsa.PrivateKey:
+		if keyFormat != "pkcs8" {
+			blockType = "EC PRIVATE KEY"
+			der, err = x509.MarshalECPrivateKey(k)
+		}
+	}
+	if blockType == "" {
+		// ed25519 keys are only encoded as pkcs8
+		blockType = "PRIVATE KEY"
+		der, err = x509.MarshalPKCS8PrivateKey(key)
+	}
+	if err != nil {
+		return "", fmt.Errorf("error encoding private key: %v", err)
+	}
+
+	if format == "der" {
+		return base64.StdEncoding.EncodeToString(der), nil
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})), nil
+}
@@ -0,0 +1,85 @@
+package vault
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+	yaml "gopkg.in/yaml.v1"
+)
+
+func unmarshalDynamicSecret(bytes []byte) (*DynamicSecret, error) {
+	var secret DynamicSecret
+	err := yaml.Unmarshal(bytes, &secret)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling lease: %v", err)
+	}
+	return &secret, nil
+}
+
+func (d *DynamicSecret) Save(path string) error {
+	//write out full secret
+	bytes, err := yaml.Marshal(d)
+	if err != nil {
+		return fmt.Errorf("error marshalling secret: %v", err)
+	}
+
+	err = ioutil.WriteFile(path, bytes, 0600)
+	if err != nil {
+		return fmt.Errorf("error writing secret to file: %v", err)
+	}
+
+	log.Printf("wrote lease to %s", path)
+	return nil
+}
+
+func (d *DynamicSecret) EnvVars() map[string]string {
+	return dataEnvVars(d.Data)
+}
+
+// dataEnvVars returns the environment with each key of a secret's
+// data added, non-scalar values are JSON encoded
+func dataEnvVars(data map[string]interface{}) map[string]string {
+	envMap := make(map[string]string)
+
+	for _, v := range os.Environ() {
+		splitEnv := strings.Split(v, "=")
+		envMap[splitEnv[0]] = splitEnv[1]
+	}
+
+	// overwrites env variables with the same name as a key in the secret
+	for k, v := range data {
+		switch v.(type) {
+		case nil:
+			envMap[k] = ""
+		case string, json.Number, bool, int, int64, float64:
+			envMap[k] = fmt.Sprint(v)
+		default:
+			b, err := json.Marshal(v)
+			if err != nil {
+				envMap[k] = fmt.Sprint(v)
+				continue
+			}
+			envMap[k] = string(b)
+		}
+	}
+
+	return envMap
+}
+
+func (d *DynamicSecret) Lease() *api.Secret {
+	return d.Secret
+}
+
+func (d *DynamicSecret) ExpireTime() (time.Time, error) {
+	return parseExpireTime(d.LeaseExpireTime)
+}
+
+func (d *DynamicSecret) SetExpireTime(expire time.Time) {
+	d.LeaseExpireTime = formatExpireTime(expire)
+}
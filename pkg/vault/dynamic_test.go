@@ -0,0 +1,60 @@
+package vault
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+)
+
+func TestDynamicSecret(t *testing.T) {
+	f := FileSecretsProvider{secretType: DynamicType, path: "/tmp/testdynamic"}
+
+	data := map[string]interface{}{"access_key": "AKIA", "secret_key": "Foo", "security_token": nil}
+	secret := api.Secret{LeaseID: "aws/creds/foo/123", Data: data}
+	dynamic := DynamicSecret{Data: data, Secret: &secret}
+	err := dynamic.Save("/tmp/testdynamic")
+	if err != nil {
+		t.Errorf("error saving testing secret: %v", err)
+	}
+	s, err := f.Fetch()
+	if err != nil {
+		t.Errorf("error reading testing secret: %v", err)
+	}
+
+	d := s.(*DynamicSecret)
+
+	if d.Lease().LeaseID != "aws/creds/foo/123" {
+		t.Errorf("lease id should be aws/creds/foo/123 got: %v", d.Lease().LeaseID)
+	}
+
+	env := d.EnvVars()
+	if env["access_key"] != "AKIA" || env["secret_key"] != "Foo" || env["security_token"] != "" {
+		t.Errorf("did not get expected secret, got access_key: %v, secret_key: %v, security_token: %v", env["access_key"], env["secret_key"], env["security_token"])
+	}
+}
+
+func TestDynamicSecretNonStringData(t *testing.T) {
+	d := DynamicSecret{Data: map[string]interface{}{"ttl": json.Number("3600")}}
+
+	if d.EnvVars()["ttl"] != "3600" {
+		t.Errorf("ttl should be 3600 got: %v", d.EnvVars()["ttl"])
+	}
+}
+
+func TestDataEnvVars(t *testing.T) {
+	env := dataEnvVars(map[string]interface{}{
+		"ttl":     json.Number("3600"),
+		"enabled": true,
+		"empty":   nil,
+		"hosts":   []interface{}{"a", "b"},
+		"config":  map[string]interface{}{"port": json.Number("5432")},
+	})
+
+	if env["ttl"] != "3600" || env["enabled"] != "true" || env["empty"] != "" {
+		t.Errorf("unexpected scalar values, got ttl: %v, enabled: %v, empty: %v", env["ttl"], env["enabled"], env["empty"])
+	}
+	if env["hosts"] != `["a","b"]` || env["config"] != `{"port":5432}` {
+		t.Errorf("expected json encoded values, got hosts: %v, config: %v", env["hosts"], env["config"])
+	}
+}
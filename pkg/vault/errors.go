@@ -0,0 +1,217 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	retryablehttp "github.com/hashicorp/go-retryablehttp"
+	"github.com/hashicorp/vault/api"
+)
+
+// RateLimitError is returned when Vault rejects a request with 429
+// Too Many Requests. RetryAfter is zero if Vault didn't say how
+// long to wait.
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("rate limited by vault, retry after %s", e.RetryAfter)
+	}
+	return "rate limited by vault"
+}
+
+// SealedError is returned when Vault responds 503, normally because
+// it is sealed or has no active node
+type SealedError struct {
+	Err error
+}
+
+func (e *SealedError) Error() string {
+	return fmt.Sprintf("vault is sealed or unavailable: %s", e.Err)
+}
+
+func (e *SealedError) Unwrap() error {
+	return e.Err
+}
+
+// NetworkError is returned when Vault couldn't be reached
+type NetworkError struct {
+	Err error
+}
+
+func (e *NetworkError) Error() string {
+	return fmt.Sprintf("unable to reach vault: %s", e.Err)
+}
+
+func (e *NetworkError) Unwrap() error {
+	return e.Err
+}
+
+// RequestError is returned when Vault rejects a request as invalid,
+// retrying the same request won't help
+type RequestError struct {
+	StatusCode int
+	Err        error
+}
+
+func (e *RequestError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+// classifyError sorts err by what caused it so that callers can
+// decide whether to retry, log in again or give up. Errors that
+// can't be classified, such as 500s, are returned unchanged and
+// are retried.
+func classifyError(err error) error {
+	if err == nil || err == ErrPermissionDenied || err == ErrLeaseNotFound {
+		return err
+	}
+
+	var rateLimited *RateLimitError
+	if errors.As(err, &rateLimited) {
+		return rateLimited
+	}
+
+	var sealed *SealedError
+	var network *NetworkError
+	var request *RequestError
+	if errors.As(err, &sealed) || errors.As(err, &network) || errors.As(err, &request) {
+		return err
+	}
+
+	var respErr *api.ResponseError
+	if errors.As(err, &respErr) {
+		switch {
+		case respErr.StatusCode == http.StatusForbidden:
+			return ErrPermissionDenied
+		case leaseGone(respErr):
+			return ErrLeaseNotFound
+		case respErr.StatusCode == http.StatusServiceUnavailable:
+			return &SealedError{Err: err}
+		case respErr.StatusCode >= 400 && respErr.StatusCode < 500:
+			return &RequestError{StatusCode: respErr.StatusCode, Err: err}
+		}
+		return err
+	}
+
+	if isConnectionError(err) {
+		return &NetworkError{Err: err}
+	}
+
+	return err
+}
+
+// leaseGone returns whether Vault rejected a lease renewal because
+// the lease has been revoked, has expired or can't be renewed
+func leaseGone(respErr *api.ResponseError) bool {
+	if respErr.StatusCode != http.StatusBadRequest && respErr.StatusCode != http.StatusNotFound {
+		return false
+	}
+
+	for _, e := range respErr.Errors {
+		e = strings.ToLower(e)
+		if strings.Contains(e, "lease not found") || strings.Contains(e, "lease is not renewable") || strings.Contains(e, "lease expired") || strings.Contains(e, "invalid lease") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// retryable classifies err and marks errors that retrying the same
+// request won't fix as permanent
+func retryable(err error) error {
+	err = classifyError(err)
+
+	var requestErr *RequestError
+	if err == ErrPermissionDenied || err == ErrLeaseNotFound || errors.As(err, &requestErr) {
+		return backoff.Permanent(err)
+	}
+
+	return err
+}
+
+// retryAfterBackOff waits at least as long as Vault asked when a
+// request was rate limited
+type retryAfterBackOff struct {
+	backoff.BackOff
+	wait time.Duration
+}
+
+func (b *retryAfterBackOff) NextBackOff() time.Duration {
+	next := b.BackOff.NextBackOff()
+	if next != backoff.Stop && next < b.wait {
+		next = b.wait
+	}
+	b.wait = 0
+	return next
+}
+
+// retry runs op until it succeeds, returns a permanent error or max
+// has elapsed
+func retry(ctx context.Context, op backoff.Operation, max time.Duration) error {
+	strategy := &retryAfterBackOff{BackOff: defaultRetryStrategy(max)}
+
+	return backoff.Retry(func() error {
+		err := op()
+		var rateLimited *RateLimitError
+		if errors.As(err, &rateLimited) {
+			strategy.wait = rateLimited.RetryAfter
+		}
+		return err
+	}, backoff.WithContext(strategy, ctx))
+}
+
+// checkRetry leaves rate limited requests to the caller, which waits
+// as long as Vault asked rather than the client's own backoff
+func checkRetry(ctx context.Context, resp *http.Response, err error) (bool, error) {
+	var rateLimited *RateLimitError
+	if errors.As(err, &rateLimited) {
+		return false, nil
+	}
+
+	return retryablehttp.DefaultRetryPolicy(ctx, resp, err)
+}
+
+// rateLimitTransport turns 429 responses into a RateLimitError that
+// carries Retry-After, which the Vault client's errors don't include
+type rateLimitTransport struct {
+	http.RoundTripper
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusTooManyRequests {
+		return resp, err
+	}
+	resp.Body.Close()
+
+	return nil, &RateLimitError{RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())}
+}
+
+// parseRetryAfter parses a Retry-After header given either in
+// seconds or as a date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
@@ -0,0 +1,103 @@
+package vault
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/hashicorp/vault/api"
+)
+
+func TestClassifyError(t *testing.T) {
+	err := classifyError(&api.ResponseError{StatusCode: 403, Errors: []string{"permission denied"}})
+	if err != ErrPermissionDenied {
+		t.Errorf("error should be permission denied got: %v", err)
+	}
+
+	err = classifyError(fmt.Errorf("error reading lease: %w", &api.ResponseError{StatusCode: 400, Errors: []string{"lease not found or lease is not renewable"}}))
+	if err != ErrLeaseNotFound {
+		t.Errorf("error should be lease not found got: %v", err)
+	}
+
+	var sealed *SealedError
+	err = classifyError(&api.ResponseError{StatusCode: 503, Errors: []string{"Vault is sealed"}})
+	if !errors.As(err, &sealed) {
+		t.Errorf("error should be sealed got: %v", err)
+	}
+
+	var request *RequestError
+	err = classifyError(&api.ResponseError{StatusCode: 404})
+	if !errors.As(err, &request) || request.StatusCode != 404 {
+		t.Errorf("error should be a request error got: %v", err)
+	}
+
+	err = classifyError(&api.ResponseError{StatusCode: 500})
+	if errors.As(err, &request) || errors.As(err, &sealed) {
+		t.Errorf("server errors should not be classified got: %v", err)
+	}
+
+	err = classifyError(fmt.Errorf("foo"))
+	if err.Error() != "foo" {
+		t.Errorf("unknown errors should be returned unchanged got: %v", err)
+	}
+
+	_, ok := retryable(&api.ResponseError{StatusCode: 400}).(*backoff.PermanentError)
+	if !ok {
+		t.Errorf("bad requests should not be retried")
+	}
+	_, ok = retryable(&api.ResponseError{StatusCode: 503}).(*backoff.PermanentError)
+	if ok {
+		t.Errorf("sealed errors should be retried")
+	}
+}
+
+func TestRateLimited(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Retry-After", "7")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"errors": ["rate limit quota exceeded"]}`))
+	}))
+	defer server.Close()
+
+	client, err := createUnauthenticatedClient(&VaultConfig{VaultAddr: server.URL, TLS: &TLSConfig{}})
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+
+	_, err = client.Logical().Read("secret/foo")
+	if requests != 1 {
+		t.Errorf("rate limited requests should be left to the caller to retry, got %d requests", requests)
+	}
+	var rateLimited *RateLimitError
+	if !errors.As(classifyError(err), &rateLimited) {
+		t.Fatalf("error should be rate limited got: %v", err)
+	}
+	if rateLimited.RetryAfter != 7*time.Second {
+		t.Errorf("expected to retry after 7s got %s", rateLimited.RetryAfter)
+	}
+
+	var network *NetworkError
+	if errors.As(classifyError(err), &network) {
+		t.Errorf("rate limited requests should not be network errors")
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	if parseRetryAfter("120", now) != 2*time.Minute {
+		t.Errorf("expected seconds to be parsed")
+	}
+	if parseRetryAfter("Wed, 01 Jan 2020 00:00:30 GMT", now) != 30*time.Second {
+		t.Errorf("expected date to be parsed")
+	}
+	if parseRetryAfter("soon", now) != 0 {
+		t.Errorf("expected invalid value to be ignored")
+	}
+}
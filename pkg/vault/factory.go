@@ -4,9 +4,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
 
-	log "github.com/sirupsen/logrus"
 	"github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
 	yaml "gopkg.in/yaml.v1"
 )
 
@@ -19,42 +22,102 @@ type KubernetesVaultClientFactory struct {
 }
 
 type FileVaultClientFactory struct {
-	vault *VaultConfig
-	path  string
+	vault   *VaultConfig
+	path    string
+	relogin ClientFactory
+}
+
+// authenticator is implemented by factories that can log in to
+// Vault again once a token reaches its max TTL
+type authenticator interface {
+	authenticate(client *api.Client) (*api.Secret, error)
 }
 
 type AuthClient struct {
 	Client *api.Client
 	secret *api.Secret
+
+	mu     sync.Mutex
+	login  func(client *api.Client) (*api.Secret, error)
+	logins int
+	path   string
+	expiry time.Time
+	// namespace the token was issued in
+	namespace string
+	// addrs the client can fail over between
+	addrs []string
+	// external tokens belong to someone else, such as Vault Agent,
+	// and are never renewed or revoked. sink is the file the agent
+	// writes the token to.
+	external bool
+	sink     string
+	// batch and periodic tokens are found with LookupSelf
+	batch  bool
+	period time.Duration
 }
 
 // Create returns a Vault client that has been authenticated
 // with the service account token. It can be used to make other
 // Vault requests
 func (f *KubernetesVaultClientFactory) Create() (*AuthClient, error) {
-	client, err := createUnauthenticatedClient(f.vault)
+	return createAuthClient(f.vault, f)
+}
+
+// createAuthClient creates a client and logs in with the
+// authenticator, which is also used for any later logins
+func createAuthClient(v *VaultConfig, a authenticator) (*AuthClient, error) {
+	client, err := createUnauthenticatedClient(v)
 	if err != nil {
 		return nil, err
 	}
 
 	var secret *api.Secret
-	secret, err = f.authenticate(client)
+	secret, err = a.authenticate(client)
 	if err != nil {
 		return nil, err
 	}
 
-	return &AuthClient{Client: client, secret: secret}, nil
+	return &AuthClient{Client: client, secret: secret, login: a.authenticate, expiry: tokenExpiry(secret), namespace: v.AuthNamespace, addrs: v.addresses()}, nil
 }
 
 func createUnauthenticatedClient(v *VaultConfig) (*api.Client, error) {
 	cfg := api.DefaultConfig()
 	cfg.Address = v.VaultAddr
-	cfg.ConfigureTLS(&api.TLSConfig{CACert: v.TLS.CACert, CAPath: v.TLS.CAPath})
+	if cfg.Error != nil {
+		return nil, cfg.Error
+	}
+	err := configureTLS(cfg, v.TLS)
+	if err != nil {
+		return nil, fmt.Errorf("error configuring tls: %v", err)
+	}
 	client, err := api.NewClient(cfg)
 	if err != nil {
 		return nil, err
 	}
 
+	if v.Namespace != "" {
+		client.SetNamespace(v.Namespace)
+	}
+
+	if addrs := v.addresses(); len(addrs) > 1 {
+		for _, addr := range addrs {
+			if strings.HasPrefix(addr, "unix://") {
+				return nil, fmt.Errorf("unix socket addresses can't be used with failover")
+			}
+		}
+		addr, err := selectAddress(client, addrs)
+		if err != nil {
+			log.Warnf("%s, using %s", err, addrs[0])
+		} else {
+			client.SetAddress(addr)
+		}
+	}
+
+	// wrapped once the client is created as unix socket addresses
+	// require the plain transport
+	cfg.HttpClient.Transport = &rateLimitTransport{cfg.HttpClient.Transport}
+	client.SetCheckRetry(checkRetry)
+
 	return client, nil
 }
 
@@ -77,19 +140,48 @@ func (f *FileVaultClientFactory) Create() (*AuthClient, error) {
 	}
 
 	client.SetToken(secret.Auth.ClientToken)
-	return &AuthClient{Client: client, secret: secret}, nil
+
+	authClient := &AuthClient{Client: client, secret: secret, path: f.path, namespace: f.vault.AuthNamespace, addrs: f.vault.addresses()}
+	if a, ok := f.relogin.(authenticator); ok {
+		authClient.login = a.authenticate
+	}
+
+	return authClient, nil
 }
 
 // Exchanges the kubernetes service account token for a vault token
 func (f *KubernetesVaultClientFactory) authenticate(client *api.Client) (*api.Secret, error) {
+	jwt, err := f.serviceAccountToken()
+	if err != nil {
+		return nil, err
+	}
+
+	return loginWith(client, f.vault.AuthNamespace, f.kube.LoginPath, &login{JWT: jwt, Role: f.kube.Role})
+}
+
+func (f *KubernetesVaultClientFactory) serviceAccountToken() (string, error) {
+	if f.kube.TokenSource != nil {
+		return f.kube.TokenSource.Token()
+	}
+
 	bytes, err := ioutil.ReadFile(f.kube.TokenFile)
 	if err != nil {
-		return nil, fmt.Errorf("error reading token: %s", err)
+		return "", fmt.Errorf("error reading token: %s", err)
 	}
 
-	req := client.NewRequest("POST", fmt.Sprintf("/v1/auth/%s", f.kube.LoginPath))
-	req.SetJSONBody(&login{JWT: string(bytes), Role: f.kube.Role})
+	return string(bytes), nil
+}
+
+// loginWith posts body to the auth method at loginPath and sets
+// the token it returns on the client. The login is sent to
+// namespace if one is given.
+func loginWith(client *api.Client, namespace, loginPath string, body interface{}) (*api.Secret, error) {
+	req := newNamespacedRequest(client, namespace, "POST", fmt.Sprintf("/v1/auth/%s", loginPath))
+	req.SetJSONBody(body)
 	resp, err := client.RawRequest(req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -103,6 +195,9 @@ func (f *KubernetesVaultClientFactory) authenticate(client *api.Client) (*api.Se
 	if err != nil {
 		return nil, fmt.Errorf("error parsing response: %s", err)
 	}
+	if secret.Auth == nil {
+		return nil, fmt.Errorf("login response contained no token")
+	}
 
 	logger := log.WithFields(secretFields(&secret))
 	logger.Infof("successfully authenticated")
@@ -115,11 +210,148 @@ func NewKubernetesAuthClientFactory(vault *VaultConfig, kube *KubernetesAuthConf
 	return &KubernetesVaultClientFactory{vault: vault, kube: kube}
 }
 
-func NewFileAuthClientFactory(vault *VaultConfig, path string) ClientFactory {
-	return &FileVaultClientFactory{vault: vault, path: path}
+// NewFileAuthClientFactory restores a token saved to path. If relogin
+// is able to authenticate it is used once the restored token expires.
+func NewFileAuthClientFactory(vault *VaultConfig, path string, relogin ClientFactory) ClientFactory {
+	return &FileVaultClientFactory{vault: vault, path: path, relogin: relogin}
+}
+
+// Reauthenticate logs in again and swaps the token used by the
+// client in place. failedToken is the token that could no longer
+// be renewed, if another caller has already replaced it there is
+// nothing to do.
+func (a *AuthClient) Reauthenticate(failedToken string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.Client.Token() != failedToken {
+		return nil
+	}
+
+	if a.login == nil {
+		return fmt.Errorf("unable to log in again, no authentication method available")
+	}
+
+	log.Infof("logging in to vault again")
+	secret, err := a.login(a.Client)
+	if err != nil {
+		return err
+	}
+
+	a.secret = secret
+	a.logins++
+	a.expiry = tokenExpiry(secret)
+
+	if a.path != "" {
+		return a.save(a.path)
+	}
+
+	return nil
+}
+
+// LookupSelf looks up the token to find out whether it is a batch
+// or periodic token, and its actual remaining TTL. A token that has
+// expired or been revoked returns ErrPermissionDenied.
+func (a *AuthClient) LookupSelf() error {
+	secret, err := lookupSelf(a.Client, a.namespace)
+	if err != nil {
+		return classifyError(err)
+	}
+
+	tokenType, _ := secret.Data["type"].(string)
+	period, _ := jsonInt(secret.Data["period"])
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.batch = tokenType == "batch"
+	a.period = time.Duration(period) * time.Second
+	a.expiry = tokenExpiry(secret)
+	if a.secret == nil {
+		a.secret = secret
+	}
+
+	log.WithFields(log.Fields{"type": tokenType, "period": a.period, "ttl": secret.Auth.LeaseDuration}).Infof("looked up vault token")
+	return nil
+}
+
+// Batch returns whether the token is a batch token, which can
+// neither be renewed nor revoked
+func (a *AuthClient) Batch() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.batch
+}
+
+// Period returns the period of a periodic token, or zero
+func (a *AuthClient) Period() time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.period
+}
+
+// TokenExpiry returns when the token is due to expire, this is
+// zero if it is unknown
+func (a *AuthClient) TokenExpiry() time.Time {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.expiry
+}
+
+// TokenDuration returns the TTL the token was last granted
+func (a *AuthClient) TokenDuration() time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.secret == nil || a.secret.Auth == nil {
+		return 0
+	}
+	return time.Duration(a.secret.Auth.LeaseDuration) * time.Second
+}
+
+func (a *AuthClient) renewed(secret *api.Secret) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.expiry = tokenExpiry(secret)
+	if a.secret != nil && a.secret.Auth != nil && secret.Auth != nil {
+		a.secret.Auth.LeaseDuration = secret.Auth.LeaseDuration
+	}
+}
+
+func tokenExpiry(secret *api.Secret) time.Time {
+	if secret == nil || secret.Auth == nil || secret.Auth.LeaseDuration == 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(time.Duration(secret.Auth.LeaseDuration) * time.Second)
+}
+
+// CanLogin returns whether the client is able to log in again
+func (a *AuthClient) CanLogin() bool {
+	return a.login != nil
+}
+
+// Logins returns the number of times the client has logged in again
+// since it was created
+func (a *AuthClient) Logins() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.logins
 }
 
 func (a *AuthClient) Save(path string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.path = path
+	return a.save(path)
+}
+
+func (a *AuthClient) save(path string) error {
 	//write out token
 	tokenBytes, err := yaml.Marshal(a.secret)
 	if err != nil {
@@ -135,3 +367,45 @@ func (a *AuthClient) Save(path string) error {
 
 	return nil
 }
+
+// RenewSelf renews the token by increment seconds
+func (a *AuthClient) RenewSelf(increment int) (*api.Secret, error) {
+	req := newNamespacedRequest(a.Client, a.namespace, "PUT", "/v1/auth/token/renew-self")
+	err := req.SetJSONBody(map[string]interface{}{"increment": increment})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := a.Client.RawRequest(req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return api.ParseSecret(resp.Body)
+}
+
+// RevokeSelf this will attempt to revoke its own token
+func (a *AuthClient) RevokeSelf() {
+	if a.external {
+		log.Infof("token is managed externally, not revoking")
+		return
+	}
+	if a.Batch() {
+		log.Infof("batch tokens can't be revoked, leaving token to expire")
+		return
+	}
+
+	req := newNamespacedRequest(a.Client, a.namespace, "PUT", "/v1/auth/token/revoke-self")
+	resp, err := a.Client.RawRequest(req)
+	if resp != nil {
+		resp.Body.Close()
+	}
+	if err != nil {
+		log.Errorf("failed to revoke self: %s", err)
+	} else {
+		log.Infof("revoked own token")
+	}
+}
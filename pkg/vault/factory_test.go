@@ -14,7 +14,7 @@ func TestAuthTokenRead(t *testing.T) {
 		t.Errorf("error saving testing credentials: %v", err)
 	}
 
-	factory := FileVaultClientFactory{path: path, vault: &VaultConfig{TLS: &TLSConfig{CACert: "foo"}}}
+	factory := FileVaultClientFactory{path: path, vault: &VaultConfig{TLS: &TLSConfig{}}}
 	auth, err := factory.Create()
 	if err != nil {
 		t.Errorf("error creating authFactory: %v", err)
@@ -24,3 +24,31 @@ func TestAuthTokenRead(t *testing.T) {
 		t.Errorf("token should be foo got: %v", auth.Client.Token())
 	}
 }
+
+func TestReauthenticate(t *testing.T) {
+	client, err := createUnauthenticatedClient(&VaultConfig{TLS: &TLSConfig{}})
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+	client.SetToken("expired")
+
+	login := func(c *api.Client) (*api.Secret, error) {
+		c.SetToken("fresh")
+		return &api.Secret{Auth: &api.SecretAuth{ClientToken: "fresh"}}, nil
+	}
+	authClient := &AuthClient{Client: client, login: login}
+
+	err = authClient.Reauthenticate("expired")
+	if err != nil {
+		t.Errorf("error logging in again: %v", err)
+	}
+	if client.Token() != "fresh" || authClient.Logins() != 1 {
+		t.Errorf("token should be fresh after 1 login got: %v after %v", client.Token(), authClient.Logins())
+	}
+
+	// a second caller with the old token should not log in again
+	err = authClient.Reauthenticate("expired")
+	if err != nil || authClient.Logins() != 1 {
+		t.Errorf("should not have logged in again, got %v logins: %v", authClient.Logins(), err)
+	}
+}
@@ -0,0 +1,121 @@
+package vault
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+
+	"github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+	"github.com/uswitch/vault-creds/pkg/metrics"
+)
+
+// addresses returns every configured Vault address in the order
+// they should be tried
+func (v *VaultConfig) addresses() []string {
+	addrs := make([]string, 0, len(v.FailoverAddrs)+1)
+	for _, addr := range append([]string{v.VaultAddr}, v.FailoverAddrs...) {
+		if addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// selectAddress returns the first address that is the active node,
+// or failing that the first healthy standby
+func selectAddress(client *api.Client, addrs []string) (string, error) {
+	standby := ""
+	for _, addr := range addrs {
+		healthy, active := checkHealth(client, addr)
+		if active {
+			return addr, nil
+		}
+		if healthy && standby == "" {
+			standby = addr
+		}
+	}
+
+	if standby == "" {
+		return "", fmt.Errorf("no healthy vault address in %v", addrs)
+	}
+
+	return standby, nil
+}
+
+// checkHealth queries sys/health on addr, returning whether the node
+// can serve requests and whether it is the active node
+func checkHealth(client *api.Client, addr string) (bool, bool) {
+	c, err := client.Clone()
+	if err != nil {
+		return false, false
+	}
+	// a node that can't be reached is skipped rather than retried
+	c.SetMaxRetries(0)
+	err = c.SetAddress(addr)
+	if err != nil {
+		return false, false
+	}
+
+	health, err := c.Sys().Health()
+	if err != nil {
+		log.WithField("address", addr).Warnf("vault health check failed: %s", err)
+		return false, false
+	}
+
+	healthy := health.Initialized && !health.Sealed && health.ReplicationDRMode != "secondary"
+	active := healthy && !health.Standby && !health.PerformanceStandby
+
+	return healthy, active
+}
+
+// isConnectionError returns whether err was caused by being unable
+// to reach Vault rather than an error response from it
+func isConnectionError(err error) bool {
+	var urlErr *url.Error
+	var netErr net.Error
+	return errors.As(err, &urlErr) || errors.As(err, &netErr)
+}
+
+// Failover moves the client to another healthy Vault address after
+// a connection error to failed. If another caller has already moved
+// the client there is nothing to do.
+func (a *AuthClient) Failover(failed string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if len(a.addrs) < 2 || a.Client.Address() != failed {
+		return nil
+	}
+
+	addr, err := selectAddress(a.Client, a.addrs)
+	if err != nil {
+		return err
+	}
+	if addr == failed {
+		return nil
+	}
+
+	log.WithFields(log.Fields{"from": failed, "to": addr}).Warnf("failing over to another vault address")
+
+	return a.Client.SetAddress(addr)
+}
+
+// failoverOnError fails over when err shows the address the request
+// was sent to couldn't be reached or is sealed, and reports the
+// address in use
+func (a *AuthClient) failoverOnError(err error, addr string, gateway *metrics.PushGateway) {
+	var network *NetworkError
+	var sealed *SealedError
+	err = classifyError(err)
+	if !errors.As(err, &network) && !errors.As(err, &sealed) {
+		return
+	}
+
+	ferr := a.Failover(addr)
+	if ferr != nil {
+		log.Errorf("error failing over: %s", ferr)
+	}
+	gateway.SetEndpoint(a.Client.Address())
+}
@@ -0,0 +1,75 @@
+package vault
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func healthServer(standby bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"initialized": true, "sealed": false, "standby": %t}`, standby)
+	}))
+}
+
+func TestSelectAddress(t *testing.T) {
+	dead := healthServer(false)
+	dead.Close()
+	standby := healthServer(true)
+	defer standby.Close()
+	active := healthServer(false)
+	defer active.Close()
+
+	client, err := createUnauthenticatedClient(&VaultConfig{TLS: &TLSConfig{}})
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+
+	addr, err := selectAddress(client, []string{dead.URL, standby.URL, active.URL})
+	if err != nil || addr != active.URL {
+		t.Errorf("expected active node %s, got %s: %v", active.URL, addr, err)
+	}
+
+	addr, err = selectAddress(client, []string{dead.URL, standby.URL})
+	if err != nil || addr != standby.URL {
+		t.Errorf("expected standby node %s, got %s: %v", standby.URL, addr, err)
+	}
+
+	_, err = selectAddress(client, []string{dead.URL})
+	if err == nil {
+		t.Errorf("expected error when no address is healthy")
+	}
+}
+
+func TestFailover(t *testing.T) {
+	dead := healthServer(false)
+	dead.Close()
+	active := healthServer(false)
+	defer active.Close()
+
+	client, err := createUnauthenticatedClient(&VaultConfig{VaultAddr: dead.URL, TLS: &TLSConfig{}})
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+	client.SetMaxRetries(0)
+	authClient := &AuthClient{Client: client, addrs: []string{dead.URL, active.URL}}
+
+	_, err = client.Sys().Health()
+	if !isConnectionError(err) {
+		t.Fatalf("expected connection error, got %v", err)
+	}
+
+	err = authClient.Failover(dead.URL)
+	if err != nil {
+		t.Errorf("error failing over: %v", err)
+	}
+	if client.Address() != active.URL {
+		t.Errorf("expected client to use %s, got %s", active.URL, client.Address())
+	}
+
+	err = authClient.Failover(dead.URL)
+	if err != nil || client.Address() != active.URL {
+		t.Errorf("expected failover from an address no longer in use to do nothing")
+	}
+}
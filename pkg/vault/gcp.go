@@ -0,0 +1,64 @@
+package vault
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+)
+
+const defaultGCPMetadataAddr = "http://metadata.google.internal"
+
+// GCPVaultClientFactory creates a Vault client authenticated
+// with the gcp auth method, using an identity token issued
+// by the GCE metadata server
+type GCPVaultClientFactory struct {
+	vault *VaultConfig
+	gcp   *GCPAuthConfig
+}
+
+func NewGCPAuthClientFactory(vault *VaultConfig, gcp *GCPAuthConfig) ClientFactory {
+	return &GCPVaultClientFactory{vault: vault, gcp: gcp}
+}
+
+// Create returns a Vault client that has been authenticated
+// with the instance's service account
+func (f *GCPVaultClientFactory) Create() (*AuthClient, error) {
+	return createAuthClient(f.vault, f)
+}
+
+func (f *GCPVaultClientFactory) authenticate(client *api.Client) (*api.Secret, error) {
+	jwt, err := f.identityToken()
+	if err != nil {
+		return nil, err
+	}
+
+	return loginWith(client, f.vault.AuthNamespace, f.gcp.LoginPath, &login{JWT: jwt, Role: f.gcp.Role})
+}
+
+// identityToken requests a token for the service account with the
+// audience Vault expects for the role
+func (f *GCPVaultClientFactory) identityToken() (string, error) {
+	addr := f.gcp.MetadataAddr
+	if addr == "" {
+		addr = defaultGCPMetadataAddr
+	}
+	serviceAccount := f.gcp.ServiceAccount
+	if serviceAccount == "" {
+		serviceAccount = "default"
+	}
+
+	params := url.Values{}
+	params.Set("audience", fmt.Sprintf("http://vault/%s", f.gcp.Role))
+	params.Set("format", "full")
+
+	path := fmt.Sprintf("/computeMetadata/v1/instance/service-accounts/%s/identity", url.PathEscape(serviceAccount))
+	body, err := getMetadata(addr, path, params, http.Header{"Metadata-Flavor": []string{"Google"}})
+	if err != nil {
+		return "", fmt.Errorf("error requesting identity token: %s", err)
+	}
+
+	return strings.TrimSpace(string(body)), nil
+}
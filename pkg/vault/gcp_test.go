@@ -0,0 +1,49 @@
+package vault
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGCPLogin(t *testing.T) {
+	metadata := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/computeMetadata/v1/instance/service-accounts/default/identity" || r.Header.Get("Metadata-Flavor") != "Google" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.URL.Query().Get("audience") != "http://vault/web" || r.URL.Query().Get("format") != "full" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte("identity-jwt"))
+	}))
+	defer metadata.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body login
+		json.NewDecoder(r.Body).Decode(&body)
+		if r.URL.Path != "/v1/auth/gcp/login" || body.Role != "web" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"auth": map[string]interface{}{"client_token": "token-for-" + body.JWT, "lease_duration": 3600},
+		})
+	}))
+	defer server.Close()
+
+	factory := NewGCPAuthClientFactory(
+		&VaultConfig{VaultAddr: server.URL, TLS: &TLSConfig{}},
+		&GCPAuthConfig{LoginPath: "gcp/login", Role: "web", MetadataAddr: metadata.URL},
+	)
+
+	auth, err := factory.Create()
+	if err != nil {
+		t.Fatalf("error logging in: %v", err)
+	}
+	if auth.Client.Token() != "token-for-identity-jwt" {
+		t.Errorf("token should be token-for-identity-jwt got: %v", auth.Client.Token())
+	}
+}
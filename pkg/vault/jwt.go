@@ -0,0 +1,39 @@
+package vault
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// JWTVaultClientFactory creates a Vault client authenticated
+// with the jwt auth method, using a JWT or OIDC token issued
+// by an external identity provider
+type JWTVaultClientFactory struct {
+	vault *VaultConfig
+	jwt   *JWTAuthConfig
+}
+
+func NewJWTAuthClientFactory(vault *VaultConfig, jwt *JWTAuthConfig) ClientFactory {
+	return &JWTVaultClientFactory{vault: vault, jwt: jwt}
+}
+
+// Create returns a Vault client that has been authenticated
+// with the JWT
+func (f *JWTVaultClientFactory) Create() (*AuthClient, error) {
+	return createAuthClient(f.vault, f)
+}
+
+// the JWT file is read on every login as short lived tokens
+// are normally rotated in place
+func (f *JWTVaultClientFactory) authenticate(client *api.Client) (*api.Secret, error) {
+	jwt, err := readValue(f.jwt.JWT, f.jwt.JWTFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading jwt: %s", err)
+	}
+	if jwt == "" {
+		return nil, fmt.Errorf("no jwt supplied")
+	}
+
+	return loginWith(client, f.vault.AuthNamespace, f.jwt.LoginPath, &login{JWT: jwt, Role: f.jwt.Role})
+}
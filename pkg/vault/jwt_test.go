@@ -0,0 +1,60 @@
+package vault
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestJWTRereadsFile(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/auth/gitlab/login" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var body login
+		json.NewDecoder(r.Body).Decode(&body)
+		if body.Role != "runner" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"auth": map[string]interface{}{"client_token": "token-for-" + body.JWT, "lease_duration": 3600},
+		})
+	}))
+	defer server.Close()
+
+	path := "/tmp/testjwt"
+	err := ioutil.WriteFile(path, []byte("first\n"), 0600)
+	if err != nil {
+		t.Fatalf("error writing jwt: %v", err)
+	}
+
+	factory := NewJWTAuthClientFactory(
+		&VaultConfig{VaultAddr: server.URL, TLS: &TLSConfig{}},
+		&JWTAuthConfig{LoginPath: "gitlab/login", Role: "runner", JWTFile: path},
+	)
+
+	auth, err := factory.Create()
+	if err != nil {
+		t.Fatalf("error logging in: %v", err)
+	}
+	if auth.Client.Token() != "token-for-first" {
+		t.Errorf("token should be token-for-first got: %v", auth.Client.Token())
+	}
+
+	err = ioutil.WriteFile(path, []byte("second\n"), 0600)
+	if err != nil {
+		t.Fatalf("error writing jwt: %v", err)
+	}
+
+	err = auth.Reauthenticate("token-for-first")
+	if err != nil {
+		t.Errorf("error logging in again: %v", err)
+	}
+	if auth.Client.Token() != "token-for-second" {
+		t.Errorf("token should be token-for-second got: %v", auth.Client.Token())
+	}
+}
@@ -0,0 +1,254 @@
+package vault
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pavlo-v-chernykh/keystore-go/v4"
+	log "github.com/sirupsen/logrus"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// Keystore formats
+const (
+	PKCS12Keystore = "pkcs12"
+	JKSKeystore    = "jks"
+)
+
+// What a keystore holds
+const (
+	KeystoreContents   = "keystore"
+	TruststoreContents = "truststore"
+)
+
+// Keystore is a PKCS#12 or Java keystore holding the certificate
+// and its key, or a truststore holding its CA chain, for services
+// that can't read PEM files
+type Keystore struct {
+	Path     string      `yaml:"path"`
+	Type     string      `yaml:"type"`
+	Contents string      `yaml:"contents"`
+	Alias    string      `yaml:"alias"`
+	Mode     os.FileMode `yaml:"mode"`
+	// PasswordFile is read for the keystore password, if it
+	// doesn't exist a password is generated and written to it
+	PasswordFile string `yaml:"password-file"`
+}
+
+// FileMode returns the configured mode, by default keystores are
+// only readable by their owner
+func (k *Keystore) FileMode() os.FileMode {
+	if k.Mode != 0 {
+		return k.Mode
+	}
+	if k.Contents == TruststoreContents {
+		return 0644
+	}
+	return 0600
+}
+
+func (k *Keystore) alias() string {
+	if k.Alias != "" {
+		return k.Alias
+	}
+	return "vault-creds"
+}
+
+// password reads the keystore password, generating one the first
+// time so it stays the same as the keystore is rewritten
+func (k *Keystore) password() (string, error) {
+	path := k.PasswordFile
+	if path == "" {
+		path = k.Path + ".password"
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err == nil {
+		return strings.TrimRight(string(b), "\r\n"), nil
+	}
+	if !os.IsNotExist(err) {
+		return "", fmt.Errorf("error reading keystore password: %v", err)
+	}
+
+	random := make([]byte, 24)
+	_, err = rand.Read(random)
+	if err != nil {
+		return "", fmt.Errorf("error generating keystore password: %v", err)
+	}
+	password := base64.RawURLEncoding.EncodeToString(random)
+
+	err = writeFile(path, []byte(password), 0600)
+	if err != nil {
+		return "", fmt.Errorf("error writing keystore password: %v", err)
+	}
+	log.Printf("wrote generated keystore password to %s", path)
+
+	return password, nil
+}
+
+// WriteKeystores rebuilds each keystore from the certificate
+func (c *Certificate) WriteKeystores(stores []*Keystore) error {
+	if len(stores) == 0 {
+		return nil
+	}
+
+	cert, err := parsePEMCertificates(c.Certificate)
+	if err != nil {
+		return fmt.Errorf("error parsing certificate: %v", err)
+	}
+	if len(cert) == 0 {
+		return fmt.Errorf("no certificate to write to keystore")
+	}
+	chain, err := parsePEMCertificates(strings.Join(c.CAChain, "\n"))
+	if err != nil {
+		return fmt.Errorf("error parsing ca chain: %v", err)
+	}
+	if len(chain) == 0 {
+		chain, err = parsePEMCertificates(c.IssuingCA)
+		if err != nil {
+			return fmt.Errorf("error parsing issuing ca: %v", err)
+		}
+	}
+
+	for _, store := range stores {
+		password, err := store.password()
+		if err != nil {
+			return err
+		}
+
+		var contents []byte
+		switch store.Contents {
+		case KeystoreContents:
+			contents, err = c.keystore(store, cert[0], chain, password)
+		case TruststoreContents:
+			contents, err = truststore(store, chain, password)
+		default:
+			err = fmt.Errorf("unknown keystore contents %s", store.Contents)
+		}
+		if err != nil {
+			return fmt.Errorf("error building %s: %v", store.Path, err)
+		}
+
+		err = writeFile(store.Path, contents, store.FileMode())
+		if err != nil {
+			return fmt.Errorf("error writing %s: %v", store.Path, err)
+		}
+
+		log.Printf("wrote %s %s to %s", store.Type, store.Contents, store.Path)
+	}
+
+	return nil
+}
+
+func (c *Certificate) keystore(store *Keystore, cert *x509.Certificate, chain []*x509.Certificate, password string) ([]byte, error) {
+	block, _ := pem.Decode([]byte(c.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("error decoding private key")
+	}
+	key, err := parsePrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	if store.Type == PKCS12Keystore {
+		return pkcs12.Modern.Encode(key, cert, chain, password)
+	}
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	entry := keystore.PrivateKeyEntry{
+		CreationTime:     time.Now(),
+		PrivateKey:       pkcs8,
+		CertificateChain: []keystore.Certificate{{Type: "X509", Content: cert.Raw}},
+	}
+	for _, ca := range chain {
+		entry.CertificateChain = append(entry.CertificateChain, keystore.Certificate{Type: "X509", Content: ca.Raw})
+	}
+
+	ks := keystore.New()
+	err = ks.SetPrivateKeyEntry(store.alias(), entry, []byte(password))
+	if err != nil {
+		return nil, err
+	}
+
+	return storeJKS(ks, password)
+}
+
+func truststore(store *Keystore, chain []*x509.Certificate, password string) ([]byte, error) {
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("vault returned no ca certificates")
+	}
+
+	if store.Type == PKCS12Keystore {
+		return pkcs12.Modern.EncodeTrustStore(chain, password)
+	}
+
+	ks := keystore.New()
+	for i, ca := range chain {
+		entry := keystore.TrustedCertificateEntry{
+			CreationTime: time.Now(),
+			Certificate:  keystore.Certificate{Type: "X509", Content: ca.Raw},
+		}
+		err := ks.SetTrustedCertificateEntry(fmt.Sprintf("%s-ca-%d", store.alias(), i), entry)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return storeJKS(ks, password)
+}
+
+func storeJKS(ks keystore.KeyStore, password string) ([]byte, error) {
+	var buf bytes.Buffer
+	err := ks.Store(&buf, []byte(password))
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func parsePEMCertificates(s string) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	rest := []byte(s)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return certs, nil
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+}
+
+// parsePrivateKey parses a DER key in any of the encodings Vault
+// returns
+func parsePrivateKey(der []byte) (interface{}, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing private key: %v", err)
+	}
+	return key, nil
+}
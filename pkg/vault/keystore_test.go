@@ -0,0 +1,150 @@
+package vault
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pavlo-v-chernykh/keystore-go/v4"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+func testCertificate(t *testing.T) *Certificate {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating key: %v", err)
+	}
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("error creating ca: %v", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating key: %v", err)
+	}
+	leaf := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "web"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, leaf, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("error creating certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("error marshalling key: %v", err)
+	}
+
+	caPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}))
+	return &Certificate{
+		Certificate: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		PrivateKey:  string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})),
+		IssuingCA:   caPEM,
+		CAChain:     []string{caPEM},
+	}
+}
+
+func TestPKCS12Keystore(t *testing.T) {
+	cert := testCertificate(t)
+	dir := t.TempDir()
+	stores := []*Keystore{
+		{Path: filepath.Join(dir, "keystore.p12"), Type: PKCS12Keystore, Contents: KeystoreContents},
+		{Path: filepath.Join(dir, "truststore.p12"), Type: PKCS12Keystore, Contents: TruststoreContents, PasswordFile: filepath.Join(dir, "keystore.p12.password")},
+	}
+
+	err := cert.WriteKeystores(stores)
+	if err != nil {
+		t.Fatalf("error writing keystores: %v", err)
+	}
+
+	password, err := ioutil.ReadFile(filepath.Join(dir, "keystore.p12.password"))
+	if err != nil || len(password) == 0 {
+		t.Fatalf("expected a generated password, got: %q, %v", password, err)
+	}
+
+	b, _ := ioutil.ReadFile(stores[0].Path)
+	_, leaf, chain, err := pkcs12.DecodeChain(b, string(password))
+	if err != nil {
+		t.Fatalf("error decoding keystore: %v", err)
+	}
+	if leaf.Subject.CommonName != "web" || len(chain) != 1 || chain[0].Subject.CommonName != "ca" {
+		t.Errorf("unexpected keystore contents, got: %v, %v", leaf.Subject, chain)
+	}
+
+	b, _ = ioutil.ReadFile(stores[1].Path)
+	trusted, err := pkcs12.DecodeTrustStore(b, string(password))
+	if err != nil || len(trusted) != 1 || trusted[0].Subject.CommonName != "ca" {
+		t.Errorf("unexpected truststore contents, got: %v, %v", trusted, err)
+	}
+
+	// the generated password is kept when the keystore is rebuilt
+	err = cert.WriteKeystores(stores)
+	if err != nil {
+		t.Fatalf("error rewriting keystores: %v", err)
+	}
+	again, _ := ioutil.ReadFile(filepath.Join(dir, "keystore.p12.password"))
+	if string(again) != string(password) {
+		t.Errorf("password should not change when the keystore is rewritten")
+	}
+}
+
+func TestJKSKeystore(t *testing.T) {
+	cert := testCertificate(t)
+	dir := t.TempDir()
+	passwordFile := filepath.Join(dir, "password")
+	err := ioutil.WriteFile(passwordFile, []byte("changeit\n"), 0600)
+	if err != nil {
+		t.Fatalf("error writing password: %v", err)
+	}
+
+	stores := []*Keystore{
+		{Path: filepath.Join(dir, "keystore.jks"), Type: JKSKeystore, Contents: KeystoreContents, Alias: "web", PasswordFile: passwordFile},
+		{Path: filepath.Join(dir, "truststore.jks"), Type: JKSKeystore, Contents: TruststoreContents, PasswordFile: passwordFile},
+	}
+
+	err = cert.WriteKeystores(stores)
+	if err != nil {
+		t.Fatalf("error writing keystores: %v", err)
+	}
+
+	b, _ := ioutil.ReadFile(stores[0].Path)
+	ks := keystore.New()
+	err = ks.Load(bytes.NewReader(b), []byte("changeit"))
+	if err != nil {
+		t.Fatalf("error loading keystore: %v", err)
+	}
+	entry, err := ks.GetPrivateKeyEntry("web", []byte("changeit"))
+	if err != nil || len(entry.CertificateChain) != 2 {
+		t.Errorf("unexpected keystore entry, got: %v, %v", entry.CertificateChain, err)
+	}
+
+	b, _ = ioutil.ReadFile(stores[1].Path)
+	ts := keystore.New()
+	err = ts.Load(bytes.NewReader(b), []byte("changeit"))
+	if err != nil {
+		t.Fatalf("error loading truststore: %v", err)
+	}
+	if !ts.IsTrustedCertificateEntry("vault-creds-ca-0") {
+		t.Errorf("expected ca in truststore, got aliases: %v", ts.Aliases())
+	}
+}
@@ -0,0 +1,118 @@
+package vault
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+	yaml "gopkg.in/yaml.v1"
+)
+
+// kvMount describes the kv secrets engine a path belongs to
+type kvMount struct {
+	path    string
+	version int
+}
+
+// kvPreflight looks up the mount serving path so that kv v2 paths
+// can be rewritten, falling back to kv v1 if the lookup fails
+func kvPreflight(client *api.Client, path string) kvMount {
+	req := client.NewRequest("GET", "/v1/sys/internal/ui/mounts/"+path)
+	resp, err := client.RawRequest(req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		log.Warnf("unable to determine kv version of %s, assuming v1: %s", path, err)
+		return kvMount{version: 1}
+	}
+
+	secret, err := api.ParseSecret(resp.Body)
+	if err != nil || secret == nil || secret.Data == nil {
+		log.Warnf("unable to determine kv version of %s, assuming v1", path)
+		return kvMount{version: 1}
+	}
+
+	mount := kvMount{version: 1}
+	mount.path, _ = secret.Data["path"].(string)
+	if options, ok := secret.Data["options"].(map[string]interface{}); ok {
+		if v, _ := options["version"].(string); v == "2" {
+			mount.version = 2
+		}
+	}
+
+	return mount
+}
+
+// apiPath rewrites a path as given by a user into the path used by
+// the kv v2 api, e.g. secret/foo becomes secret/data/foo
+func (k kvMount) apiPath(path, prefix string) string {
+	if k.version != 2 {
+		return path
+	}
+
+	rest := strings.TrimPrefix(path, k.path)
+	rest = strings.TrimPrefix(rest, "data/")
+	rest = strings.TrimPrefix(rest, "metadata/")
+
+	return fmt.Sprintf("%s%s/%s", k.path, prefix, rest)
+}
+
+func parseKVSecret(secret *api.Secret, version int) (*KVSecret, error) {
+	if version != 2 {
+		return &KVSecret{Data: secret.Data, Secret: secret}, nil
+	}
+
+	data, ok := secret.Data["data"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("secret has no data, it may have been deleted")
+	}
+
+	kv := &KVSecret{Data: data, Secret: secret}
+	if metadata, ok := secret.Data["metadata"].(map[string]interface{}); ok {
+		kv.Version, _ = jsonInt(metadata["version"])
+	}
+
+	return kv, nil
+}
+
+func jsonInt(v interface{}) (int, error) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("%v is not a number", v)
+	}
+	i, err := n.Int64()
+	return int(i), err
+}
+
+func unmarshalKVSecret(bytes []byte) (*KVSecret, error) {
+	var secret KVSecret
+	err := yaml.Unmarshal(bytes, &secret)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling secret: %v", err)
+	}
+	return &secret, nil
+}
+
+func (k *KVSecret) Save(path string) error {
+	//write out full secret
+	bytes, err := yaml.Marshal(k)
+	if err != nil {
+		return fmt.Errorf("error marshalling secret: %v", err)
+	}
+
+	err = ioutil.WriteFile(path, bytes, 0600)
+	if err != nil {
+		return fmt.Errorf("error writing secret to file: %v", err)
+	}
+
+	log.Printf("wrote secret to %s", path)
+	return nil
+}
+
+func (k *KVSecret) EnvVars() map[string]string {
+	return dataEnvVars(k.Data)
+}
@@ -0,0 +1,227 @@
+package vault
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+)
+
+func TestKVPath(t *testing.T) {
+	v2 := kvMount{path: "secret/", version: 2}
+
+	paths := map[string]string{
+		"secret/foo/bar":          "secret/data/foo/bar",
+		"secret/data/foo/bar":     "secret/data/foo/bar",
+		"secret/metadata/foo/bar": "secret/data/foo/bar",
+	}
+	for path, expected := range paths {
+		if result := v2.apiPath(path, "data"); result != expected {
+			t.Errorf("path should be %v got: %v", expected, result)
+		}
+	}
+
+	if result := v2.apiPath("secret/foo", "metadata"); result != "secret/metadata/foo" {
+		t.Errorf("path should be secret/metadata/foo got: %v", result)
+	}
+
+	v1 := kvMount{path: "kv/", version: 1}
+	if result := v1.apiPath("kv/foo", "data"); result != "kv/foo" {
+		t.Errorf("path should be kv/foo got: %v", result)
+	}
+}
+
+func TestParseKVSecret(t *testing.T) {
+	secret := &api.Secret{Data: map[string]interface{}{
+		"data":     map[string]interface{}{"api_key": "foo"},
+		"metadata": map[string]interface{}{"version": json.Number("3")},
+	}}
+
+	kv, err := parseKVSecret(secret, 2)
+	if err != nil {
+		t.Fatalf("error parsing kv secret: %v", err)
+	}
+	if kv.Version != 3 || kv.EnvVars()["api_key"] != "foo" {
+		t.Errorf("did not get expected secret, got version: %v, api_key: %v", kv.Version, kv.EnvVars()["api_key"])
+	}
+
+	deleted := &api.Secret{Data: map[string]interface{}{
+		"data":     nil,
+		"metadata": map[string]interface{}{"version": json.Number("4")},
+	}}
+	_, err = parseKVSecret(deleted, 2)
+	if err == nil {
+		t.Errorf("expected error for deleted secret")
+	}
+
+	kv, err = parseKVSecret(&api.Secret{Data: map[string]interface{}{"api_key": "bar"}}, 1)
+	if err != nil || kv.Version != 0 || kv.EnvVars()["api_key"] != "bar" {
+		t.Errorf("did not get expected v1 secret, got: %v, %v", kv, err)
+	}
+}
+
+// kvStore fakes a kv v2 mount at secret/ holding secret/app
+type kvStore struct {
+	mu            sync.Mutex
+	version       int
+	metadataReads int
+}
+
+func (s *kvStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.URL.Path {
+	case "/v1/sys/internal/ui/mounts/secret/app":
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{"path": "secret/", "type": "kv", "options": map[string]interface{}{"version": "2"}},
+		})
+	case "/v1/secret/metadata/app":
+		s.metadataReads++
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"current_version": s.version}})
+	case "/v1/secret/data/app":
+		version := s.version
+		if v := r.URL.Query().Get("version"); v != "" {
+			version, _ = strconv.Atoi(v)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{
+			"data":     map[string]interface{}{"api_key": fmt.Sprintf("key-%d", version)},
+			"metadata": map[string]interface{}{"version": version},
+		}})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (s *kvStore) write() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+}
+
+func newKVManager(t *testing.T, store *kvStore, options map[string]string) *DefaultManager {
+	return newTestManager(t, store, KVType, "secret/app", "{{ .api_key }}", options, ManagerConfig{})
+}
+
+func TestKVPreflight(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/sys/internal/ui/mounts/secret/foo":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"data": map[string]interface{}{"path": "secret/", "options": map[string]interface{}{"version": "2"}},
+			})
+		case "/v1/sys/internal/ui/mounts/kv/foo":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"data": map[string]interface{}{"path": "kv/", "options": nil},
+			})
+		default:
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+
+	client, err := createUnauthenticatedClient(&VaultConfig{VaultAddr: server.URL, TLS: &TLSConfig{}})
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+	client.SetMaxRetries(0)
+
+	if mount := kvPreflight(client, "secret/foo"); mount.version != 2 || mount.path != "secret/" {
+		t.Errorf("expected kv v2 mount at secret/, got: %+v", mount)
+	}
+	if mount := kvPreflight(client, "kv/foo"); mount.version != 1 {
+		t.Errorf("expected kv v1 mount, got: %+v", mount)
+	}
+	if mount := kvPreflight(client, "denied/foo"); mount.version != 1 {
+		t.Errorf("expected failed lookup to assume kv v1, got: %+v", mount)
+	}
+}
+
+func TestRefreshKV(t *testing.T) {
+	store := &kvStore{version: 1}
+
+	manager := newKVManager(t, store, nil)
+	out := manager.outPath
+	err := manager.Save()
+	if err != nil {
+		t.Fatalf("error saving secret: %v", err)
+	}
+
+	// an unchanged version is not rendered again
+	os.Remove(out)
+	err = manager.refreshKV()
+	if err != nil {
+		t.Fatalf("error refreshing secret: %v", err)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("unchanged secret should not be rendered again")
+	}
+
+	store.write()
+	err = manager.refreshKV()
+	if err != nil {
+		t.Fatalf("error refreshing secret: %v", err)
+	}
+	rendered, _ := ioutil.ReadFile(out)
+	if string(rendered) != "key-2" {
+		t.Errorf("expected new version to be rendered, got: %s", rendered)
+	}
+}
+
+func TestRefreshKVRetriesFailedWrite(t *testing.T) {
+	store := &kvStore{version: 1}
+
+	manager := newKVManager(t, store, nil)
+	out := manager.outPath
+
+	// a directory in the way of the output makes the write fail
+	os.Mkdir(out, 0700)
+	store.write()
+	err := manager.refreshKV()
+	if err == nil {
+		t.Fatalf("expected error writing secret")
+	}
+	if kv := manager.secret.(*KVSecret); kv.Version != 1 {
+		t.Errorf("secret should not change when it couldn't be written, got version %d", kv.Version)
+	}
+
+	os.Remove(out)
+	err = manager.refreshKV()
+	if err != nil {
+		t.Fatalf("error refreshing secret: %v", err)
+	}
+	rendered, _ := ioutil.ReadFile(out)
+	if string(rendered) != "key-2" {
+		t.Errorf("expected the failed write to be retried, got: %s", rendered)
+	}
+}
+
+func TestRefreshPinnedKV(t *testing.T) {
+	store := &kvStore{version: 1}
+	store.write()
+
+	manager := newKVManager(t, store, map[string]string{"version": "1"})
+	out := manager.outPath
+	if kv := manager.secret.(*KVSecret); kv.Version != 1 || kv.Data["api_key"] != "key-1" {
+		t.Errorf("expected pinned version 1, got: %v", kv.Data)
+	}
+
+	store.write()
+	err := manager.refreshKV()
+	if err != nil {
+		t.Fatalf("error refreshing secret: %v", err)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("pinned secret should never be rendered again")
+	}
+	if store.metadataReads != 0 {
+		t.Errorf("pinned secret should not be checked for new versions")
+	}
+}
@@ -3,8 +3,10 @@ package vault
 import (
 	"context"
 	"fmt"
+	"math/rand"
 	"os"
 	"path/filepath"
+	"reflect"
 	"text/template"
 	"time"
 
@@ -14,29 +16,68 @@ import (
 	"github.com/uswitch/vault-creds/pkg/metrics"
 )
 
+// minRenewInterval stops renewals happening in a tight loop as a
+// lease approaches its expiry
+const minRenewInterval = 5 * time.Second
+
+// ManagerConfig holds the settings used to renew and write out
+// a single secret
+type ManagerConfig struct {
+	Lease         time.Duration
+	Renew         time.Duration
+	RenewFraction float64
+	RenewJitter   float64
+	RevokeGrace   time.Duration
+	OutPath       string
+	LeasePath     string
+	// Files are the separate files certificates are written to
+	Files []*CertificateFile
+	// Keystores are rebuilt every time the certificate is renewed
+	Keystores []*Keystore
+}
+
 type DefaultManager struct {
-	client   *api.Client
-	secret   Secret
-	lease    time.Duration
-	renew    time.Duration
-	provider *VaultSecretsProvider
-	template *template.Template
-	gateway  *metrics.PushGateway
-	outPath  string
+	auth      *AuthClient
+	client    *api.Client
+	logins    int
+	secret    Secret
+	lease     time.Duration
+	renew     time.Duration
+	fraction  float64
+	jitter    float64
+	grace     time.Duration
+	provider  *VaultSecretsProvider
+	template  *template.Template
+	gateway   *metrics.PushGateway
+	outPath   string
+	leasePath string
+	files     []*CertificateFile
+	keystores []*Keystore
+	// reauthenticated is set once a renewal has logged in again
+	// after being denied
+	reauthenticated bool
+	// pending is a replacement secret that couldn't be written, it
+	// is written again rather than requesting yet another lease
+	pending       Secret
+	pendingLogins int
 }
 
 func (m DefaultManager) Run(ctx context.Context, c chan int) {
 	go func() {
 		_, isCert := m.secret.(*Certificate)
+		_, isKV := m.secret.(*KVSecret)
 		if isCert {
-			log.Printf("renewing certificate every %s", m.renew)
+			log.Printf("renewing certificate at %.0f%% of its lifetime", m.fraction*100)
+		} else if isKV {
+			log.Printf("checking kv secret for changes every %s", m.renew)
 		} else {
-			log.Printf("renewing %s lease every %s", m.lease, m.renew)
+			log.Printf("renewing %s lease at %.0f%% of its remaining TTL, at least every %s", m.lease, m.fraction*100, m.renew)
 		}
 
-		creds, isSecret := m.secret.(*Credentials)
-
-		renewTicks := time.Tick(m.renew)
+		next := m.nextRenewal()
+		log.Infof("next renewal in %s", next)
+		renewTimer := time.NewTimer(next)
+		defer renewTimer.Stop()
 		metricTicks := time.Tick(5 * time.Second)
 
 		for {
@@ -44,12 +85,17 @@ func (m DefaultManager) Run(ctx context.Context, c chan int) {
 			case <-ctx.Done():
 				log.Infof("stopping renewal")
 				return
-			case <-renewTicks:
+			case <-renewTimer.C:
 				err := m.Renew(ctx)
 				if err != nil {
 					m.gateway.SetFailureTime()
 					m.gateway.SetFailureCount()
 					log.Errorf("error renewing secret: %s", err)
+					if cert, ok := m.secret.(*Certificate); ok {
+						if _, notAfter := cert.Validity(); time.Now().After(notAfter) {
+							log.Errorf("certificate expired at %s", notAfter)
+						}
+					}
 				} else {
 					m.gateway.SetSuccessTime()
 				}
@@ -65,9 +111,13 @@ func (m DefaultManager) Run(ctx context.Context, c chan int) {
 					}
 				}
 				m.gateway.Push()
+
+				next := m.nextRenewal()
+				log.Infof("next renewal in %s", next)
+				renewTimer.Reset(next)
 			case <-metricTicks:
-				if isSecret {
-					expireTime, err := time.Parse(time.RFC3339, *creds.LeaseExpireTime)
+				if leased, isLeased := m.secret.(LeasedSecret); isLeased {
+					expireTime, err := leased.ExpireTime()
 					if err != nil {
 						log.Errorf("error parsing time: %s", err)
 					}
@@ -80,52 +130,151 @@ func (m DefaultManager) Run(ctx context.Context, c chan int) {
 
 }
 
-//RevokeSelf this will attempt to revoke its own token
-func (m *DefaultManager) RevokeSelf(ctx context.Context) {
+// nextRenewal returns how long to wait before renewing the secret again
+func (m *DefaultManager) nextRenewal() time.Duration {
+	var expiry time.Time
+
+	switch secret := m.secret.(type) {
+	case LeasedSecret:
+		expiry, _ = secret.ExpireTime()
+	case *Certificate:
+		// certificates are replaced rather than renewed so are
+		// not held to the renewal interval
+		notBefore, notAfter := secret.Validity()
+		return certificateRenewal(notBefore, notAfter, time.Now(), m.fraction, m.jitter)
+	}
 
-	err := m.client.Auth().Token().RevokeSelf("")
-	if err != nil {
-		log.Errorf("failed to revoke self: %s", err)
-	} else {
-		log.Infof("revoked own token")
+	var ttl time.Duration
+	if !expiry.IsZero() {
+		ttl = time.Until(expiry)
 	}
 
+	return nextRenewal(ttl, m.fraction, m.jitter, m.renew)
 }
 
-func (m *DefaultManager) Renew(ctx context.Context) error {
+// nextRenewal returns a fraction of the remaining ttl, no longer than
+// max, with random jitter applied so that many instances started at
+// the same time don't renew in lockstep. max is used when the ttl
+// isn't known. Jitter never takes the wait past max or the ttl.
+func nextRenewal(ttl time.Duration, fraction, jitter float64, max time.Duration) time.Duration {
+	next := max
+	if ttl > 0 {
+		next = time.Duration(float64(ttl) * fraction)
+		if next > max {
+			next = max
+		}
+	}
 
-	op := func() error {
-		return renewAuth(m.client, int(m.lease.Seconds()))
+	if jitter > 0 {
+		next += time.Duration((rand.Float64()*2 - 1) * jitter * float64(next))
+	}
+	if next > max {
+		next = max
+	}
+	if ttl > 0 && next > ttl {
+		next = ttl
 	}
 
-	err := backoff.Retry(op, backoff.WithContext(defaultRetryStrategy(m.lease), ctx))
+	if next < minRenewInterval {
+		next = minRenewInterval
+	}
 
-	if err != nil {
-		return err
+	return next
+}
+
+// certificateRenewal returns how long to wait before replacing a
+// certificate, once fraction of its lifetime has passed. Without a
+// start the lifetime is measured from now.
+func certificateRenewal(notBefore, notAfter, now time.Time, fraction, jitter float64) time.Duration {
+	if notBefore.IsZero() || notBefore.After(now) {
+		notBefore = now
 	}
+	lifetime := notAfter.Sub(notBefore)
 
-	creds, isCreds := m.secret.(*Credentials)
-	if isCreds {
-		logger := log.WithField("leaseID", creds.Secret.LeaseID)
+	offset := time.Duration(float64(lifetime) * fraction)
+	if jitter > 0 {
+		offset += time.Duration((rand.Float64()*2 - 1) * jitter * float64(offset))
+	}
+	if offset > lifetime {
+		offset = lifetime
+	}
+
+	next := notBefore.Add(offset).Sub(now)
+	if next < minRenewInterval {
+		next = minRenewInterval
+	}
+
+	return next
+}
+
+func (m *DefaultManager) Renew(ctx context.Context) error {
+	leased, isLeased := m.secret.(LeasedSecret)
+	_, isKV := m.secret.(*KVSecret)
+	if isLeased {
+		logger := log.WithField("leaseID", leased.Lease().LeaseID)
 		logger.Infof("renewing lease by %s.", m.lease)
+	} else if isKV {
+		log.Infof("checking kv secret for changes.")
 	} else {
 		logger := log.StandardLogger()
-		logger.Infof("renewing certificate for %s.", m.renew)
+		logger.Infof("renewing certificate.")
 	}
 
-	op = func() error {
-		if isCreds {
-			return m.renewSecret(creds.Secret.LeaseID)
+	// a certificate is retried for as long as the current one is
+	// still valid
+	max := m.lease
+	if cert, isCert := m.secret.(*Certificate); isCert {
+		_, notAfter := cert.Validity()
+		max = time.Until(notAfter)
+		if max < minRenewInterval {
+			max = minRenewInterval
 		}
-		return m.renewCertificate()
 	}
 
-	err = backoff.Retry(op, backoff.WithContext(defaultRetryStrategy(m.lease), ctx))
+	m.reauthenticated = false
+	op := func() error {
+		addr := m.client.Address()
+		var err error
+		if isLeased {
+			err = m.renewSecret(ctx, leased)
+		} else if isKV {
+			err = m.refreshKV()
+		} else {
+			err = m.renewCertificate()
+		}
+		m.auth.failoverOnError(err, addr, m.gateway)
+		return err
+	}
 
-	return err
+	return retry(ctx, op, max)
 }
 
 func (m *DefaultManager) Save() error {
+	return m.save(m.secret)
+}
+
+// save renders secret and writes its lease
+func (m *DefaultManager) save(secret Secret) error {
+	if cert, ok := secret.(*Certificate); ok {
+		err := cert.WriteFiles(m.files)
+		if err != nil {
+			return err
+		}
+		err = cert.WriteKeystores(m.keystores)
+		if err != nil {
+			return err
+		}
+	}
+
+	// certificates can be written only to files or keystores
+	// without a template
+	if m.template == nil {
+		if m.leasePath != "" {
+			return secret.Save(m.leasePath)
+		}
+		return nil
+	}
+
 	if m.outPath != "" {
 		// Ensure directory for destination file exists
 		destinationDirectory := filepath.Dir(m.outPath)
@@ -140,75 +289,223 @@ func (m *DefaultManager) Save() error {
 		}
 		defer file.Close()
 
-		m.template.Execute(file, m.secret.EnvVars())
+		err = m.template.Execute(file, secret.EnvVars())
+		if err != nil {
+			return fmt.Errorf("error rendering template: %v", err)
+		}
 
 		log.Printf("wrote secrets to %s", file.Name())
 
-		return m.secret.Save(fmt.Sprintf("%s.lease", m.outPath))
+		return secret.Save(m.leasePath)
 	}
 
-	m.template.Execute(os.Stdout, m.secret.EnvVars())
+	m.template.Execute(os.Stdout, secret.EnvVars())
 
 	return nil
 }
 
-func (m *DefaultManager) renewSecret(leaseID string) error {
-	secret, err := m.client.Sys().Renew(leaseID, int(m.lease.Seconds()))
+func (m *DefaultManager) renewSecret(ctx context.Context, leased LeasedSecret) error {
+	token := m.client.Token()
+	secret, err := m.client.Sys().Renew(leased.Lease().LeaseID, int(m.lease.Seconds()))
 	if err != nil || secret == nil {
 		if err == nil {
 			err = fmt.Errorf("secret is nil")
 		}
 		log.Errorf("error renewing lease: %s", err)
-		fatalError := checkFatalError(err)
-		if fatalError != nil {
-			return backoff.Permanent(fatalError)
+		err = classifyError(err)
+		if err == ErrLeaseNotFound {
+			// the lease has expired or been revoked
+			return m.refetch()
 		}
-		return err
+		if err == ErrPermissionDenied && m.logins != m.auth.Logins() {
+			// the lease was most likely revoked along with the
+			// token that requested it, so request a new secret
+			return m.refetch()
+		}
+		return m.handleError(token, err)
 	}
 	log.WithFields(secretFields(secret)).Infof("successfully renewed secret")
 
-	creds, _ := m.secret.(*Credentials)
-	expire := time.Now().Add(time.Duration(secret.LeaseDuration) * time.Second).Format(time.RFC3339)
-	creds.LeaseExpireTime = &expire
+	ttl := time.Duration(secret.LeaseDuration) * time.Second
+	granted := time.Duration(leased.Lease().LeaseDuration) * time.Second
+	leased.Lease().LeaseDuration = secret.LeaseDuration
+	leased.SetExpireTime(time.Now().Add(ttl))
+
+	if leaseExpiring(ttl, m.lease, granted) {
+		log.WithField("leaseID", leased.Lease().LeaseID).Infof("lease has reached its max TTL, rotating credentials")
+		return m.rotate(ctx, leased)
+	}
+	if m.pending != nil {
+		// the renewal that found the lease capped couldn't write
+		// its replacement
+		log.WithField("leaseID", leased.Lease().LeaseID).Infof("writing replacement credentials")
+		return m.rotate(ctx, leased)
+	}
+
+	return nil
+}
+
+// rotate replaces a lease that is about to reach its max TTL and
+// revokes the old lease once applications have had time to switch
+// to the new credentials
+func (m *DefaultManager) rotate(ctx context.Context, old LeasedSecret) error {
+	err := m.refetch()
+	if err != nil {
+		return err
+	}
+
+	leaseID := old.Lease().LeaseID
+	go func() {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(m.grace):
+		}
+
+		err := m.client.Sys().Revoke(leaseID)
+		if err != nil {
+			log.WithField("leaseID", leaseID).Errorf("error revoking old lease: %s", err)
+			return
+		}
+		log.WithField("leaseID", leaseID).Infof("revoked old lease")
+	}()
 
 	return nil
 }
 
+// leaseExpiring returns whether a lease or token has reached its max
+// TTL: it was granted less than both the requested increment and
+// the TTL granted previously
+func leaseExpiring(ttl, increment, previous time.Duration) bool {
+	return ttl < increment && ttl < previous
+}
+
 func (m *DefaultManager) renewCertificate() error {
 	log.Infof("renewing certificate")
-	var err error
-	m.secret, err = m.provider.newCertificate()
+	token := m.client.Token()
+	certificate, err := m.provider.newCertificate()
 	if err != nil {
 		log.Errorf("error renewing certificate: %s", err)
-		fatalError := checkFatalError(err)
-		if fatalError != nil {
-			return backoff.Permanent(fatalError)
-		}
-		return err
+		return m.handleError(token, err)
 	}
+	m.secret = certificate
 
 	return nil
 }
 
-func renewAuth(client *api.Client, renew int) error {
-	secret, err := client.Auth().Token().RenewSelf(renew)
-	if err != nil || secret == nil {
-		if err == nil {
-			err = fmt.Errorf("secret is nil")
+// handleError decides whether a failed request should be retried.
+// Requests that were denied are retried once after logging in
+// again in case the token has expired or been revoked.
+func (m *DefaultManager) handleError(token string, err error) error {
+	err = classifyError(err)
+	if err != ErrPermissionDenied || m.reauthenticated || !m.auth.CanLogin() {
+		return retryable(err)
+	}
+
+	m.reauthenticated = true
+	log.Warnf("permission denied, logging in again")
+	rerr := m.auth.Reauthenticate(token)
+	if rerr != nil {
+		return rerr
+	}
+
+	return err
+}
+
+// refreshKV re-renders the template if the kv secret has changed
+func (m *DefaultManager) refreshKV() error {
+	current, _ := m.secret.(*KVSecret)
+	if m.provider.kvPinned() {
+		return nil
+	}
+
+	if current.Version != 0 {
+		version, err := m.provider.kvCurrentVersion()
+		if err == nil && version == current.Version {
+			return nil
 		}
-		log.Errorf("error renewing token: %s", err)
-		fatalError := checkFatalError(err)
-		if fatalError != nil {
-			return backoff.Permanent(fatalError)
+		if err != nil {
+			log.Warnf("error reading kv metadata, reading secret instead: %s", err)
 		}
+	}
+
+	token := m.client.Token()
+	kv, err := m.provider.newKVSecret()
+	if err != nil {
+		log.Errorf("error reading kv secret: %s", err)
+		return m.handleError(token, err)
+	}
+
+	if kv.Version == current.Version && reflect.DeepEqual(kv.Data, current.Data) {
+		return nil
+	}
+
+	log.WithFields(log.Fields{"from": current.Version, "to": kv.Version}).Infof("kv secret changed")
+	// the new version is only kept once written so that a failed
+	// write is retried
+	err = m.save(kv)
+	if err != nil {
 		return err
 	}
-	log.WithFields(secretFields(secret)).Infof("successfully renewed auth token")
+	m.secret = kv
 
 	return nil
 }
 
-func NewManager(client *api.Client, secret Secret, lease time.Duration, renew time.Duration, provider *VaultSecretsProvider, template *template.Template, gateway *metrics.PushGateway, outPath string) CredentialsRenewer {
+// refetch replaces a secret that can no longer be renewed. The
+// new secret is only kept once written, a secret that couldn't be
+// written is kept aside and written again on the next attempt.
+func (m *DefaultManager) refetch() error {
+	if m.provider == nil {
+		return backoff.Permanent(ErrLeaseNotFound)
+	}
+
+	secret, logins := m.pending, m.pendingLogins
+	if secret == nil || logins != m.auth.Logins() {
+		// a pending lease is revoked along with the token that
+		// requested it
+		log.Infof("requesting new secret")
+		token := m.client.Token()
+		logins = m.auth.Logins()
+		var err error
+		secret, err = m.provider.Fetch()
+		if err != nil {
+			log.Errorf("error requesting new secret: %s", err)
+			return m.handleError(token, err)
+		}
+	}
 
-	return &DefaultManager{client: client, secret: secret, lease: lease, renew: renew, provider: provider, template: template, gateway: gateway, outPath: outPath}
+	err := m.save(secret)
+	if err != nil {
+		m.pending, m.pendingLogins = secret, logins
+		return err
+	}
+
+	m.secret = secret
+	m.logins = logins
+	m.pending = nil
+
+	return nil
+}
+
+func NewManager(auth *AuthClient, secret Secret, provider *VaultSecretsProvider, template *template.Template, gateway *metrics.PushGateway, config ManagerConfig) CredentialsRenewer {
+
+	return &DefaultManager{
+		auth:      auth,
+		client:    auth.Client,
+		logins:    auth.Logins(),
+		secret:    secret,
+		lease:     config.Lease,
+		renew:     config.Renew,
+		fraction:  config.RenewFraction,
+		jitter:    config.RenewJitter,
+		grace:     config.RevokeGrace,
+		provider:  provider,
+		template:  template,
+		gateway:   gateway,
+		outPath:   config.OutPath,
+		leasePath: config.LeasePath,
+		files:     config.Files,
+		keystores: config.Keystores,
+	}
 }
@@ -0,0 +1,310 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/uswitch/vault-creds/pkg/metrics"
+)
+
+// newTestManager builds a manager for the secret of secretType at
+// path served by handler. The template is written to a temporary
+// file unless config sets OutPath.
+func newTestManager(t *testing.T, handler http.Handler, secretType SecretType, path, text string, options map[string]string, config ManagerConfig) *DefaultManager {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	client, err := createUnauthenticatedClient(&VaultConfig{VaultAddr: server.URL, TLS: &TLSConfig{}})
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+	client.SetToken("token")
+
+	provider := NewVaultSecretsProvider(client, secretType, path, options).(*VaultSecretsProvider)
+	secret, err := provider.Fetch()
+	if err != nil {
+		t.Fatalf("error fetching secret: %v", err)
+	}
+
+	if config.OutPath == "" {
+		config.OutPath = filepath.Join(t.TempDir(), "out")
+	}
+	if config.LeasePath == "" {
+		config.LeasePath = config.OutPath + ".lease"
+	}
+
+	return NewManager(&AuthClient{Client: client}, secret, provider, template.Must(template.New(path).Parse(text)), metrics.NewPushGateway(""), config).(*DefaultManager)
+}
+
+// cappedLeases fakes a database secrets engine whose leases have
+// reached their max TTL, so every renewal is capped
+type cappedLeases struct {
+	mu      sync.Mutex
+	issued  int
+	revoked []string
+}
+
+func (s *cappedLeases) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case r.URL.Path == "/v1/database/creds/app":
+		s.issued++
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"lease_id":       fmt.Sprintf("database/creds/app/%d", s.issued),
+			"lease_duration": 3600,
+			"renewable":      true,
+			"data":           map[string]interface{}{"username": fmt.Sprintf("user-%d", s.issued), "password": "secret"},
+		})
+	case r.URL.Path == "/v1/sys/leases/renew":
+		var body struct {
+			LeaseID string `json:"lease_id"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		json.NewEncoder(w).Encode(map[string]interface{}{"lease_id": body.LeaseID, "lease_duration": 60, "renewable": true})
+	case r.URL.Path == "/v1/sys/leases/revoke":
+		var body struct {
+			LeaseID string `json:"lease_id"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		s.revoked = append(s.revoked, body.LeaseID)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (s *cappedLeases) issuedLeases() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.issued
+}
+
+func (s *cappedLeases) revokedLeases() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.revoked...)
+}
+
+// waitForRevocation waits until a lease has been revoked and for
+// long enough that any further revocations would have been made
+func (s *cappedLeases) waitForRevocation() []string {
+	deadline := time.Now().Add(2 * time.Second)
+	for len(s.revokedLeases()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(200 * time.Millisecond)
+	return s.revokedLeases()
+}
+
+func newCappedLeaseManager(t *testing.T, leases *cappedLeases, grace time.Duration) *DefaultManager {
+	manager := newTestManager(t, leases, CredentialType, "database/creds/app", "{{ .Username }}", nil, ManagerConfig{
+		Lease:       time.Hour,
+		Renew:       time.Minute,
+		RevokeGrace: grace,
+	})
+
+	err := manager.Save()
+	if err != nil {
+		t.Fatalf("error saving secret: %v", err)
+	}
+
+	return manager
+}
+
+func TestRotateCappedLease(t *testing.T) {
+	leases := &cappedLeases{}
+	manager := newCappedLeaseManager(t, leases, 100*time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	err := manager.Renew(ctx)
+	if err != nil {
+		t.Fatalf("error renewing: %v", err)
+	}
+
+	rendered, _ := ioutil.ReadFile(manager.outPath)
+	if string(rendered) != "user-2" {
+		t.Errorf("expected new credentials to be rendered, got: %s", rendered)
+	}
+	if creds := manager.secret.(*Credentials); creds.Lease().LeaseID != "database/creds/app/2" {
+		t.Errorf("expected the new lease, got: %v", creds.Lease().LeaseID)
+	}
+	if revoked := leases.revokedLeases(); len(revoked) != 0 {
+		t.Errorf("old lease should not be revoked before the grace period, got: %v", revoked)
+	}
+
+	revoked := leases.waitForRevocation()
+	if len(revoked) != 1 || revoked[0] != "database/creds/app/1" {
+		t.Errorf("expected only the old lease to be revoked once, got: %v", revoked)
+	}
+}
+
+func TestRotateShutdownDuringGrace(t *testing.T) {
+	leases := &cappedLeases{}
+	manager := newCappedLeaseManager(t, leases, 100*time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	err := manager.Renew(ctx)
+	if err != nil {
+		t.Fatalf("error renewing: %v", err)
+	}
+	// leases are revoked along with the token on shutdown
+	cancel()
+
+	time.Sleep(300 * time.Millisecond)
+	if revoked := leases.revokedLeases(); len(revoked) != 0 {
+		t.Errorf("old lease should not be revoked after shutdown, got: %v", revoked)
+	}
+}
+
+func TestRotateRetriesFailedWrite(t *testing.T) {
+	leases := &cappedLeases{}
+	manager := newCappedLeaseManager(t, leases, 100*time.Millisecond)
+
+	// a directory in the way of the output makes the write fail
+	os.Remove(manager.outPath)
+	os.Mkdir(manager.outPath, 0700)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	attempts, stop := context.WithTimeout(ctx, 1500*time.Millisecond)
+	defer stop()
+
+	err := manager.Renew(attempts)
+	if err == nil {
+		t.Fatalf("expected error writing secret")
+	}
+	if creds := manager.secret.(*Credentials); creds.Lease().LeaseID != "database/creds/app/1" {
+		t.Errorf("secret should not change when it couldn't be written, got: %v", creds.Lease().LeaseID)
+	}
+	if issued := leases.issuedLeases(); issued != 2 {
+		t.Errorf("expected a single new lease to be requested while the write fails, got %d leases", issued)
+	}
+
+	os.Remove(manager.outPath)
+	err = manager.Renew(ctx)
+	if err != nil {
+		t.Fatalf("error renewing: %v", err)
+	}
+
+	rendered, _ := ioutil.ReadFile(manager.outPath)
+	if string(rendered) != "user-2" {
+		t.Errorf("expected the failed write to be retried, got: %s", rendered)
+	}
+	if issued := leases.issuedLeases(); issued != 2 {
+		t.Errorf("expected the unwritten lease to be used, got %d leases", issued)
+	}
+
+	revoked := leases.waitForRevocation()
+	if len(revoked) != 1 || revoked[0] != "database/creds/app/1" {
+		t.Errorf("expected only the old lease to be revoked once, got: %v", revoked)
+	}
+}
+
+// rejectedRenewals fakes a database secrets engine that refuses to
+// renew its leases with status
+type rejectedRenewals struct {
+	status  int
+	message string
+
+	mu     sync.Mutex
+	issued int
+}
+
+func (s *rejectedRenewals) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.URL.Path {
+	case "/v1/database/creds/app":
+		s.issued++
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"lease_id":       fmt.Sprintf("database/creds/app/%d", s.issued),
+			"lease_duration": 3600,
+			"renewable":      true,
+			"data":           map[string]interface{}{"username": fmt.Sprintf("user-%d", s.issued), "password": "secret"},
+		})
+	case "/v1/sys/leases/renew":
+		w.WriteHeader(s.status)
+		json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{s.message}})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newRejectedRenewalManager(t *testing.T, leases *rejectedRenewals) *DefaultManager {
+	manager := newTestManager(t, leases, CredentialType, "database/creds/app", "{{ .Username }}", nil, ManagerConfig{
+		Lease: time.Hour,
+		Renew: time.Minute,
+	})
+	manager.client.SetMaxRetries(0)
+
+	return manager
+}
+
+func TestRenewRefetchesLostLease(t *testing.T) {
+	leases := &rejectedRenewals{status: http.StatusBadRequest, message: "lease not found or lease is not renewable"}
+	manager := newRejectedRenewalManager(t, leases)
+
+	err := manager.Renew(context.Background())
+	if err != nil {
+		t.Fatalf("error renewing: %v", err)
+	}
+
+	rendered, _ := ioutil.ReadFile(manager.outPath)
+	if string(rendered) != "user-2" {
+		t.Errorf("expected new credentials to be rendered, got: %s", rendered)
+	}
+	if creds := manager.secret.(*Credentials); creds.Lease().LeaseID != "database/creds/app/2" {
+		t.Errorf("expected the new lease, got: %v", creds.Lease().LeaseID)
+	}
+}
+
+func TestRenewRefetchesDeniedLeaseAfterLogin(t *testing.T) {
+	leases := &rejectedRenewals{status: http.StatusForbidden, message: "permission denied"}
+	manager := newRejectedRenewalManager(t, leases)
+
+	// the token has been replaced since the lease was requested
+	manager.auth.logins++
+
+	err := manager.Renew(context.Background())
+	if err != nil {
+		t.Fatalf("error renewing: %v", err)
+	}
+
+	rendered, _ := ioutil.ReadFile(manager.outPath)
+	if string(rendered) != "user-2" {
+		t.Errorf("expected new credentials to be rendered, got: %s", rendered)
+	}
+	if manager.logins != manager.auth.Logins() {
+		t.Errorf("expected the new secret to be recorded against the current login")
+	}
+}
+
+func TestRenewDeniedLeaseWithoutLogin(t *testing.T) {
+	leases := &rejectedRenewals{status: http.StatusForbidden, message: "permission denied"}
+	manager := newRejectedRenewalManager(t, leases)
+
+	err := manager.Renew(context.Background())
+	if err != ErrPermissionDenied {
+		t.Errorf("expected permission denied, got: %v", err)
+	}
+	if leases.issued != 1 {
+		t.Errorf("a denied lease should not be replaced without logging in again, got %d leases", leases.issued)
+	}
+	if _, err := os.Stat(manager.outPath); !os.IsNotExist(err) {
+		t.Errorf("nothing should be rendered when the lease is denied")
+	}
+}
@@ -0,0 +1,37 @@
+package vault
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+var metadataClient = &http.Client{Timeout: 10 * time.Second}
+
+// getMetadata requests path from a cloud instance metadata
+// service at addr
+func getMetadata(addr, path string, params url.Values, header http.Header) ([]byte, error) {
+	req, err := http.NewRequest("GET", addr+path+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = header
+
+	resp, err := metadataClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error requesting %s: %s", path, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %s", path, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error requesting %s: %s %s", path, resp.Status, body)
+	}
+
+	return body, nil
+}
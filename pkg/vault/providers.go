@@ -1,9 +1,12 @@
 package vault
 
 import (
+	"crypto"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/hashicorp/vault/api"
@@ -16,6 +19,7 @@ type VaultSecretsProvider struct {
 	path       string
 	secretType SecretType
 	options    map[string]string
+	kv         *kvMount
 }
 
 type FileSecretsProvider struct {
@@ -35,8 +39,13 @@ func NewFileSecretsProvider(secretType SecretType, path string, options map[stri
 func (c *VaultSecretsProvider) Fetch() (Secret, error) {
 	log.Infof("requesting %v", c.secretType)
 
-	if c.secretType == CertificateType {
+	switch c.secretType {
+	case CertificateType:
 		return c.newCertificate()
+	case DynamicType:
+		return c.newDynamicSecret()
+	case KVType:
+		return c.newKVSecret()
 	}
 
 	return c.newCredentials()
@@ -48,7 +57,36 @@ func (c *VaultSecretsProvider) newCertificate() (*Certificate, error) {
 		params[k] = interface{}(v)
 	}
 
-	secret, err := c.client.Logical().Write(c.path, params)
+	path := c.path
+	if ref, ok := c.options["issuer_ref"]; ok {
+		// the issuer is chosen by path rather than by parameter
+		delete(params, "issuer_ref")
+		var err error
+		path, err = issuerPath(c.path, ref)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// with a key type the key is generated here and only a CSR is
+	// sent to Vault to sign
+	var key crypto.Signer
+	if keyType, ok := c.options["key_type"]; ok {
+		delete(params, "key_type")
+		delete(params, "key_bits")
+		delete(params, "private_key_format")
+
+		bits, _ := strconv.Atoi(c.options["key_bits"])
+		var csr string
+		var err error
+		key, csr, err = newCSR(keyType, bits, c.options["common_name"])
+		if err != nil {
+			return nil, err
+		}
+		params["csr"] = csr
+	}
+
+	secret, err := c.client.Logical().Write(path, params)
 	if err != nil || secret == nil {
 		if err == nil {
 			return nil, fmt.Errorf("secret is nil")
@@ -56,11 +94,43 @@ func (c *VaultSecretsProvider) newCertificate() (*Certificate, error) {
 		return nil, err
 	}
 
+	certificate, err := parseCertificate(secret, c.options["format"])
+	if err != nil {
+		return nil, err
+	}
+
+	if key != nil {
+		certificate.PrivateKey, err = encodePrivateKey(key, c.options["format"], c.options["private_key_format"])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return certificate, nil
+}
+
+func parseCertificate(secret *api.Secret, format string) (*Certificate, error) {
 	exp, err := secret.Data["expiration"].(json.Number).Int64()
 	if err != nil {
 		return nil, err
 	}
 
+	// only PEM certificates can be parsed, other formats are passed
+	// through as Vault returned them
+	issuingCA, _ := secret.Data["issuing_ca"].(string)
+	if format != "" && format != "pem" {
+		certificate, _ := secret.Data["certificate"].(string)
+		privateKey, _ := secret.Data["private_key"].(string)
+		chain, _ := secret.Data["ca_chain"].([]interface{})
+		caChain := make([]string, 0, len(chain))
+		for _, c := range chain {
+			if ca, ok := c.(string); ok {
+				caChain = append(caChain, ca)
+			}
+		}
+		return &Certificate{Certificate: certificate, PrivateKey: privateKey, IssuingCA: issuingCA, CAChain: caChain, Expiration: exp, Secret: secret}, nil
+	}
+
 	parsedBundle, err := certutil.ParsePKIMap(secret.Data)
 	if err != nil {
 		return nil, err
@@ -71,10 +141,121 @@ func (c *VaultSecretsProvider) newCertificate() (*Certificate, error) {
 		return nil, err
 	}
 
-	return &Certificate{Certificate: bundle.Certificate, PrivateKey: bundle.PrivateKey, Expiration: exp, Secret: secret}, nil
+	return &Certificate{
+		Certificate: bundle.Certificate,
+		PrivateKey:  bundle.PrivateKey,
+		IssuingCA:   strings.TrimSpace(issuingCA),
+		CAChain:     bundle.CAChain,
+		Expiration:  exp,
+		Secret:      secret,
+	}, nil
+}
+
+// issuerPath rewrites a pki/issue/<role> or pki/sign/<role> path
+// to use the named issuer, pki/issuer/<ref>/issue/<role>
+func issuerPath(path, ref string) (string, error) {
+	i := strings.LastIndex(path, "/issue/")
+	if i < 0 {
+		i = strings.LastIndex(path, "/sign/")
+	}
+	if i < 0 {
+		return "", fmt.Errorf("can't set issuer on %s, expected a path of the form <mount>/issue/<role> or <mount>/sign/<role>", path)
+	}
+
+	return path[:i] + "/issuer/" + ref + path[i:], nil
 }
 
 func (c *VaultSecretsProvider) newCredentials() (*Credentials, error) {
+	secret, err := c.readLeased()
+	if err != nil {
+		return nil, err
+	}
+
+	username, ok := secret.Data["username"].(string)
+	if !ok {
+		return nil, fmt.Errorf("secret at %s has no username, use the %s secret type for other engines", c.path, DynamicType)
+	}
+	password, ok := secret.Data["password"].(string)
+	if !ok {
+		return nil, fmt.Errorf("secret at %s has no password, use the %s secret type for other engines", c.path, DynamicType)
+	}
+
+	return &Credentials{
+		Username:        username,
+		Password:        password,
+		Secret:          secret,
+		LeaseExpireTime: leaseExpireTime(secret),
+	}, nil
+}
+
+func (c *VaultSecretsProvider) newDynamicSecret() (*DynamicSecret, error) {
+	secret, err := c.readLeased()
+	if err != nil {
+		return nil, err
+	}
+
+	return &DynamicSecret{
+		Data:            secret.Data,
+		Secret:          secret,
+		LeaseExpireTime: leaseExpireTime(secret),
+	}, nil
+}
+
+func (c *VaultSecretsProvider) newKVSecret() (*KVSecret, error) {
+	mount := c.kvMount()
+
+	var data map[string][]string
+	if version, ok := c.options["version"]; ok && mount.version == 2 {
+		data = map[string][]string{"version": {version}}
+	}
+
+	secret, err := c.client.Logical().ReadWithData(mount.apiPath(c.path, "data"), data)
+	if err != nil || secret == nil {
+		if err == nil {
+			return nil, fmt.Errorf("secret is nil")
+		}
+		return nil, err
+	}
+
+	kv, err := parseKVSecret(secret, mount.version)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", c.path, err)
+	}
+
+	return kv, nil
+}
+
+// kvCurrentVersion returns the latest version of a kv v2 secret
+func (c *VaultSecretsProvider) kvCurrentVersion() (int, error) {
+	mount := c.kvMount()
+
+	secret, err := c.client.Logical().Read(mount.apiPath(c.path, "metadata"))
+	if err != nil || secret == nil {
+		if err == nil {
+			return 0, fmt.Errorf("secret metadata is nil")
+		}
+		return 0, err
+	}
+
+	return jsonInt(secret.Data["current_version"])
+}
+
+// kvPinned returns whether the kv secret is pinned to a version
+// and so will never change
+func (c *VaultSecretsProvider) kvPinned() bool {
+	_, ok := c.options["version"]
+	return ok && c.kvMount().version == 2
+}
+
+func (c *VaultSecretsProvider) kvMount() kvMount {
+	if c.kv == nil {
+		mount := kvPreflight(c.client, c.path)
+		c.kv = &mount
+	}
+	return *c.kv
+}
+
+func (c *VaultSecretsProvider) readLeased() (*api.Secret, error) {
 	secret, err := c.client.Logical().Read(c.path)
 	if err != nil || secret == nil {
 		if err == nil {
@@ -83,25 +264,27 @@ func (c *VaultSecretsProvider) newCredentials() (*Credentials, error) {
 		return nil, err
 	}
 
-	leaseExpireTime := time.Now().Add(time.Duration(secret.LeaseDuration) * time.Second).Format(time.RFC3339)
+	return secret, nil
+}
 
-	return &Credentials{
-		Username:        secret.Data["username"].(string),
-		Password:        secret.Data["password"].(string),
-		Secret:          secret,
-		LeaseExpireTime: &leaseExpireTime,
-	}, nil
+func leaseExpireTime(secret *api.Secret) *string {
+	return formatExpireTime(time.Now().Add(time.Duration(secret.LeaseDuration) * time.Second))
 }
 
 func (c *FileSecretsProvider) Fetch() (Secret, error) {
 	log.Infof("detected existing lease")
 	bytes, err := ioutil.ReadFile(c.path)
 	if err != nil {
-		return nil, fmt.Errorf("error reading lease: %v", err)
+		return nil, fmt.Errorf("error reading lease: %w", err)
 	}
 
-	if c.secretType == CertificateType {
+	switch c.secretType {
+	case CertificateType:
 		return unmarshalCertificate(bytes)
+	case DynamicType:
+		return unmarshalDynamicSecret(bytes)
+	case KVType:
+		return unmarshalKVSecret(bytes)
 	}
 
 	return unmarshalCredentials(bytes)
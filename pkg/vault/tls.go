@@ -0,0 +1,217 @@
+package vault
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-rootcerts"
+	"github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+)
+
+var tlsVersions = map[string]uint16{
+	"tls10": tls.VersionTLS10,
+	"tls11": tls.VersionTLS11,
+	"tls12": tls.VersionTLS12,
+	"tls13": tls.VersionTLS13,
+}
+
+// configureTLS applies t to the client's transport. The CA and client
+// certificate are loaded up front so that mistakes are reported at
+// startup, and are then reloaded whenever the files change.
+func configureTLS(cfg *api.Config, t *TLSConfig) error {
+	transport := cfg.HttpClient.Transport.(*http.Transport)
+	clientTLSConfig := transport.TLSClientConfig
+
+	if t.MinVersion != "" {
+		version, ok := tlsVersions[t.MinVersion]
+		if !ok {
+			return fmt.Errorf("unknown tls version %s", t.MinVersion)
+		}
+		clientTLSConfig.MinVersion = version
+	}
+
+	if t.ServerName != "" {
+		clientTLSConfig.ServerName = t.ServerName
+	}
+
+	if (t.ClientCert == "") != (t.ClientKey == "") {
+		return fmt.Errorf("both client cert and client key must be provided")
+	}
+
+	if t.CACert == "" && t.CAPath == "" && t.ClientCert == "" {
+		return nil
+	}
+
+	reloader := &tlsReloader{config: t}
+	err := reloader.reload()
+	if err != nil {
+		return err
+	}
+
+	if t.ClientCert != "" {
+		clientTLSConfig.GetClientCertificate = reloader.clientCertificate
+	}
+
+	if t.CACert != "" || t.CAPath != "" {
+		// RootCAs can't be swapped once the transport is in use so
+		// each connection is made with a copy of the config holding
+		// the latest CA. Connections through a proxy keep the CA
+		// loaded at startup.
+		clientTLSConfig.RootCAs, _ = reloader.current()
+		transport.DialTLSContext = reloader.dialTLS(transport)
+	}
+
+	return nil
+}
+
+// tlsReloader holds the CA pool and client certificate, reloading
+// them when the files they were read from are modified
+type tlsReloader struct {
+	config *TLSConfig
+
+	mu       sync.Mutex
+	modified time.Time
+	roots    *x509.CertPool
+	cert     *tls.Certificate
+}
+
+// reload reads the files again if any have changed since they were
+// last loaded
+func (r *tlsReloader) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	modified, err := r.lastModified()
+	if err != nil {
+		return err
+	}
+	if !modified.After(r.modified) {
+		return nil
+	}
+
+	var roots *x509.CertPool
+	if r.config.CACert != "" || r.config.CAPath != "" {
+		roots, err = rootcerts.LoadCACerts(&rootcerts.Config{CAFile: r.config.CACert, CAPath: r.config.CAPath})
+		if err != nil {
+			return fmt.Errorf("error loading ca certificate: %v", err)
+		}
+	}
+
+	var cert *tls.Certificate
+	if r.config.ClientCert != "" {
+		pair, err := tls.LoadX509KeyPair(r.config.ClientCert, r.config.ClientKey)
+		if err != nil {
+			return fmt.Errorf("error loading client certificate: %v", err)
+		}
+		cert = &pair
+	}
+
+	if !r.modified.IsZero() {
+		log.Infof("reloaded vault tls certificates")
+	}
+
+	r.roots = roots
+	r.cert = cert
+	r.modified = modified
+
+	return nil
+}
+
+// lastModified returns the latest modification time of the
+// configured files. Symlinks are followed so that files mounted
+// from kubernetes secrets are seen to change.
+func (r *tlsReloader) lastModified() (time.Time, error) {
+	paths := []string{r.config.CACert, r.config.ClientCert, r.config.ClientKey}
+
+	if r.config.CAPath != "" {
+		files, err := ioutil.ReadDir(r.config.CAPath)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("error reading ca path: %v", err)
+		}
+		for _, f := range files {
+			paths = append(paths, filepath.Join(r.config.CAPath, f.Name()))
+		}
+	}
+
+	var latest time.Time
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+
+	return latest, nil
+}
+
+// current reloads the files if needed and returns what is loaded.
+// A failed reload is logged and the previous files are kept.
+func (r *tlsReloader) current() (*x509.CertPool, *tls.Certificate) {
+	err := r.reload()
+	if err != nil {
+		log.Errorf("error reloading vault tls certificates: %s", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.roots, r.cert
+}
+
+func (r *tlsReloader) clientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	_, cert := r.current()
+	return cert, nil
+}
+
+// dialTLS returns a dialer that verifies the server against the
+// latest CA. The server name is the one configured, or else the
+// host or IP address dialed.
+func (r *tlsReloader) dialTLS(transport *http.Transport) func(context.Context, string, string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+
+		roots, _ := r.current()
+		config := transport.TLSClientConfig.Clone()
+		config.RootCAs = roots
+		if config.ServerName == "" {
+			config.ServerName = host
+		}
+
+		dial := transport.DialContext
+		if dial == nil {
+			dial = (&net.Dialer{}).DialContext
+		}
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConn := tls.Client(conn, config)
+		err = tlsConn.HandshakeContext(ctx)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		return tlsConn, nil
+	}
+}
@@ -0,0 +1,216 @@
+package vault
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeCertificate(t *testing.T, dir, name string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("error creating certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("error marshalling key: %v", err)
+	}
+
+	certPath := filepath.Join(dir, "client.crt")
+	keyPath := filepath.Join(dir, "client.key")
+	writePEM(t, certPath, "CERTIFICATE", der)
+	writePEM(t, keyPath, "EC PRIVATE KEY", keyDER)
+
+	return certPath, keyPath
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600)
+	if err != nil {
+		t.Fatalf("error writing %s: %v", path, err)
+	}
+	// make sure the change is seen even on filesystems with
+	// coarse modification times
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(path, later, later)
+}
+
+func TestTLSReloadsFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var presented string
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		presented = r.TLS.PeerCertificates[0].Subject.CommonName
+		w.Write([]byte("{}"))
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	caPath := filepath.Join(dir, "ca.crt")
+	writePEM(t, caPath, "CERTIFICATE", server.Certificate().Raw)
+	certPath, keyPath := writeCertificate(t, dir, "first")
+
+	client, err := createUnauthenticatedClient(&VaultConfig{
+		VaultAddr: server.URL,
+		TLS:       &TLSConfig{CACert: caPath, ClientCert: certPath, ClientKey: keyPath, MinVersion: "tls12"},
+	})
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+	client.SetMaxRetries(0)
+
+	request := func() error {
+		server.CloseClientConnections()
+		resp, err := client.RawRequest(client.NewRequest("GET", "/v1/sys/health"))
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	err = request()
+	if err != nil {
+		t.Fatalf("error making request: %v", err)
+	}
+	if presented != "first" {
+		t.Errorf("expected client certificate first, got %s", presented)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	writeCertificate(t, dir, "second")
+	err = request()
+	if err != nil {
+		t.Fatalf("error making request: %v", err)
+	}
+	if presented != "second" {
+		t.Errorf("expected reloaded client certificate second, got %s", presented)
+	}
+
+	otherDir := filepath.Join(dir, "other")
+	os.Mkdir(otherDir, 0700)
+	otherCA, _ := writeCertificate(t, otherDir, "other")
+	os.Rename(otherCA, caPath)
+
+	err = request()
+	if err == nil {
+		t.Errorf("expected request to fail once the ca was replaced")
+	}
+}
+
+func TestTLSConfigErrors(t *testing.T) {
+	configs := []*TLSConfig{
+		{CACert: "/does/not/exist"},
+		{ClientCert: "/tmp/client.crt"},
+		{MinVersion: "ssl3"},
+	}
+
+	for _, c := range configs {
+		_, err := createUnauthenticatedClient(&VaultConfig{TLS: c})
+		if err == nil {
+			t.Errorf("expected error creating client with %+v", c)
+		}
+	}
+}
+
+func TestTLSVerifiesServerName(t *testing.T) {
+	dir := t.TempDir()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating key: %v", err)
+	}
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("error creating ca: %v", err)
+	}
+	caPath := filepath.Join(dir, "ca.crt")
+	writePEM(t, caPath, "CERTIFICATE", caDER)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating key: %v", err)
+	}
+	leaf := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "attacker.example"},
+		DNSNames:     []string{"attacker.example"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, leaf, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("error creating certificate: %v", err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{}"))
+	}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	server.StartTLS()
+	defer server.Close()
+
+	request := func(serverName string) error {
+		client, err := createUnauthenticatedClient(&VaultConfig{
+			VaultAddr: server.URL,
+			TLS:       &TLSConfig{CACert: caPath, ServerName: serverName},
+		})
+		if err != nil {
+			t.Fatalf("error creating client: %v", err)
+		}
+		client.SetMaxRetries(0)
+
+		resp, err := client.RawRequest(client.NewRequest("GET", "/v1/sys/health"))
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	err = request("")
+	if err == nil {
+		t.Errorf("expected certificate for attacker.example to be rejected for %s", server.URL)
+	}
+
+	err = request("attacker.example")
+	if err != nil {
+		t.Errorf("expected certificate to be accepted with a matching server name: %v", err)
+	}
+}
@@ -0,0 +1,227 @@
+package vault
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+	"github.com/uswitch/vault-creds/pkg/metrics"
+)
+
+// TokenManagerConfig holds the settings used to renew the Vault
+// token shared by every secret
+type TokenManagerConfig struct {
+	Increment     time.Duration
+	Renew         time.Duration
+	RenewFraction float64
+	RenewJitter   float64
+}
+
+// TokenManager renews the Vault token independently of the
+// leases of the secrets it was used to request
+type TokenManager struct {
+	auth      *AuthClient
+	increment time.Duration
+	renew     time.Duration
+	fraction  float64
+	jitter    float64
+	gateway   *metrics.PushGateway
+}
+
+func NewTokenManager(auth *AuthClient, gateway *metrics.PushGateway, config TokenManagerConfig) *TokenManager {
+	return &TokenManager{
+		auth:      auth,
+		increment: config.Increment,
+		renew:     config.Renew,
+		fraction:  config.RenewFraction,
+		jitter:    config.RenewJitter,
+		gateway:   gateway,
+	}
+}
+
+func (t *TokenManager) Run(ctx context.Context, c chan int) {
+	if t.auth.sink != "" {
+		go t.watchSink(ctx)
+		return
+	}
+	if t.auth.external {
+		log.Printf("token is managed externally, not renewing")
+		return
+	}
+	if t.auth.Batch() && !t.auth.CanLogin() {
+		log.Warnf("batch token can't be renewed and will expire at %s", t.auth.TokenExpiry())
+		return
+	}
+
+	go func() {
+		if t.auth.Batch() {
+			log.Printf("batch token can't be renewed, logging in again at %.0f%% of its remaining TTL", t.fraction*100)
+		} else if period := t.auth.Period(); period > 0 {
+			log.Printf("renewing periodic token by its period of %s at %.0f%% of its remaining TTL, at least every %s", period, t.fraction*100, t.renew)
+		} else {
+			log.Printf("renewing token by %s at %.0f%% of its remaining TTL, at least every %s", t.increment, t.fraction*100, t.renew)
+		}
+
+		next := t.nextRenewal()
+		log.Infof("next token renewal in %s", next)
+		renewTimer := time.NewTimer(next)
+		defer renewTimer.Stop()
+		metricTicks := time.Tick(5 * time.Second)
+
+		for {
+			select {
+			case <-ctx.Done():
+				log.Infof("stopping token renewal")
+				return
+			case <-renewTimer.C:
+				err := t.Renew(ctx)
+				if err != nil {
+					t.gateway.SetTokenFailureTime()
+					t.gateway.SetTokenFailureCount()
+					log.Errorf("error renewing token: %s", err)
+				} else {
+					t.gateway.SetTokenSuccessTime()
+				}
+				if err == ErrPermissionDenied || err == ErrLeaseNotFound {
+					log.Error("token could no longer be renewed")
+					c <- 1
+					return
+				}
+				t.gateway.Push()
+
+				next := t.nextRenewal()
+				log.Infof("next token renewal in %s", next)
+				renewTimer.Reset(next)
+			case <-metricTicks:
+				expiry := t.auth.TokenExpiry()
+				if !expiry.IsZero() {
+					t.gateway.SetTokenExpiration(time.Until(expiry))
+					t.gateway.Push()
+				}
+			}
+		}
+	}()
+}
+
+// watchSink picks up tokens rotated by Vault Agent, leases requested
+// with the old token are requested again once they can't be renewed
+func (t *TokenManager) watchSink(ctx context.Context) {
+	log.Printf("token is managed by vault agent, watching %s for changes", t.auth.sink)
+
+	ticks := time.NewTicker(sinkPollInterval)
+	defer ticks.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Infof("stopping token watch")
+			return
+		case <-ticks.C:
+			err := t.checkSink()
+			if err != nil {
+				t.gateway.SetTokenFailureTime()
+				t.gateway.SetTokenFailureCount()
+				log.Errorf("error reading rotated token: %s", err)
+			}
+			if expiry := t.auth.TokenExpiry(); !expiry.IsZero() {
+				t.gateway.SetTokenExpiration(time.Until(expiry))
+			}
+			t.gateway.Push()
+		}
+	}
+}
+
+func (t *TokenManager) checkSink() error {
+	token, err := readValue("", t.auth.sink)
+	if err != nil {
+		return err
+	}
+
+	current := t.auth.Client.Token()
+	if token == "" || token == current {
+		return nil
+	}
+
+	log.Infof("vault agent rotated the token")
+	err = t.auth.Reauthenticate(current)
+	if err != nil {
+		return err
+	}
+	t.gateway.SetTokenSuccessTime()
+
+	return nil
+}
+
+func (t *TokenManager) Renew(ctx context.Context) error {
+	op := func() error {
+		addr := t.auth.Client.Address()
+		err := t.renewAuth()
+		t.auth.failoverOnError(err, addr, t.gateway)
+		return err
+	}
+
+	return retry(ctx, op, t.increment)
+}
+
+func (t *TokenManager) nextRenewal() time.Duration {
+	var ttl time.Duration
+	if expiry := t.auth.TokenExpiry(); !expiry.IsZero() {
+		ttl = time.Until(expiry)
+	}
+
+	return nextRenewal(ttl, t.fraction, t.jitter, t.renew)
+}
+
+func (t *TokenManager) renewAuth() error {
+	client := t.auth.Client
+	token := client.Token()
+	granted := t.auth.TokenDuration()
+
+	if t.auth.Batch() {
+		log.Infof("logging in again before batch token expires")
+		return t.auth.Reauthenticate(token)
+	}
+
+	// periodic tokens are always renewed by their period, and only
+	// expire if they were also given an explicit max TTL
+	increment := t.increment
+	if period := t.auth.Period(); period > 0 {
+		increment = period
+	}
+
+	secret, err := t.auth.RenewSelf(int(increment.Seconds()))
+	if err != nil || secret == nil {
+		if err == nil {
+			err = fmt.Errorf("secret is nil")
+		}
+		log.Errorf("error renewing token: %s", err)
+		err = classifyError(err)
+		if (err == ErrPermissionDenied || err == ErrLeaseNotFound) && t.auth.CanLogin() {
+			// the token has most likely expired after reaching its max TTL
+			return t.auth.Reauthenticate(token)
+		}
+		return retryable(err)
+	}
+	log.WithFields(secretFields(secret)).Infof("successfully renewed auth token")
+	t.auth.renewed(secret)
+
+	if t.auth.CanLogin() && tokenExpiring(secret.Auth, increment, granted) {
+		log.Infof("auth token has reached its max TTL")
+		return t.auth.Reauthenticate(token)
+	}
+
+	return nil
+}
+
+// tokenExpiring returns whether a renewed token can't be renewed
+// any further, normally because it has reached its max TTL
+func tokenExpiring(auth *api.SecretAuth, increment, previous time.Duration) bool {
+	if auth == nil || auth.LeaseDuration == 0 {
+		return false
+	}
+
+	ttl := time.Duration(auth.LeaseDuration) * time.Second
+	return !auth.Renewable || leaseExpiring(ttl, increment, previous)
+}
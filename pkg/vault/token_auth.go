@@ -0,0 +1,90 @@
+package vault
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// TokenVaultClientFactory creates a Vault client from an existing
+// token, such as one left by `vault login`, or by logging in with
+// a username and password. It is intended for running vault-creds
+// outside of a cluster.
+type TokenVaultClientFactory struct {
+	vault *VaultConfig
+	token *TokenAuthConfig
+
+	mu sync.Mutex
+	// password is kept so that the user is only prompted once
+	password string
+}
+
+type passwordLogin struct {
+	Password string `json:"password"`
+}
+
+func NewTokenAuthClientFactory(vault *VaultConfig, token *TokenAuthConfig) ClientFactory {
+	return &TokenVaultClientFactory{vault: vault, token: token}
+}
+
+// Create returns a Vault client using the token. A token that was
+// given rather than logged in for belongs to the user, so it isn't
+// renewed or revoked.
+func (f *TokenVaultClientFactory) Create() (*AuthClient, error) {
+	auth, err := createAuthClient(f.vault, f)
+	if err != nil {
+		return nil, err
+	}
+	auth.external = f.token.Username == ""
+
+	return auth, nil
+}
+
+func (f *TokenVaultClientFactory) authenticate(client *api.Client) (*api.Secret, error) {
+	if f.token.Username != "" {
+		password, err := f.readPassword()
+		if err != nil {
+			return nil, err
+		}
+		return loginWith(client, f.vault.AuthNamespace, fmt.Sprintf("%s/%s", f.token.LoginPath, f.token.Username), &passwordLogin{Password: password})
+	}
+
+	token, err := readValue(f.token.Token, f.token.TokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading token: %s", err)
+	}
+	if token == "" {
+		return nil, fmt.Errorf("no vault token supplied")
+	}
+
+	client.SetToken(token)
+	secret, err := lookupSelf(client, f.vault.AuthNamespace)
+	if err != nil {
+		return nil, err
+	}
+
+	log.WithFields(secretFields(secret)).Infof("using existing vault token")
+	return secret, nil
+}
+
+func (f *TokenVaultClientFactory) readPassword() (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.password != "" {
+		return f.password, nil
+	}
+	if f.token.PasswordPrompt == nil {
+		return "", fmt.Errorf("no way to read a password for %s", f.token.Username)
+	}
+
+	password, err := f.token.PasswordPrompt()
+	if err != nil {
+		return "", fmt.Errorf("error reading password: %s", err)
+	}
+	f.password = password
+
+	return password, nil
+}
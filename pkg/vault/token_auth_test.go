@@ -0,0 +1,90 @@
+package vault
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestTokenFile(t *testing.T) {
+	revoked := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth/token/lookup-self":
+			if r.Header.Get("X-Vault-Token") != "developer" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"ttl": 3600}})
+		case "/v1/auth/token/revoke-self":
+			revoked = true
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), ".vault-token")
+	err := ioutil.WriteFile(path, []byte("developer"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	factory := NewTokenAuthClientFactory(&VaultConfig{VaultAddr: server.URL, TLS: &TLSConfig{}}, &TokenAuthConfig{TokenFile: path})
+	auth, err := factory.Create()
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+	if auth.Client.Token() != "developer" {
+		t.Errorf("token should be developer got: %v", auth.Client.Token())
+	}
+
+	auth.RevokeSelf()
+	if revoked {
+		t.Errorf("a token belonging to the user should not be revoked")
+	}
+}
+
+func TestUserpassPromptsOnce(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body passwordLogin
+		json.NewDecoder(r.Body).Decode(&body)
+		if r.URL.Path != "/v1/auth/ldap/login/alice" || body.Password != "hunter2" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"auth": map[string]interface{}{"client_token": "alice-token", "lease_duration": 3600},
+		})
+	}))
+	defer server.Close()
+
+	prompts := 0
+	prompt := func() (string, error) {
+		prompts++
+		return "hunter2", nil
+	}
+
+	factory := NewTokenAuthClientFactory(
+		&VaultConfig{VaultAddr: server.URL, TLS: &TLSConfig{}},
+		&TokenAuthConfig{LoginPath: "ldap/login", Username: "alice", PasswordPrompt: prompt},
+	)
+	auth, err := factory.Create()
+	if err != nil {
+		t.Fatalf("error logging in: %v", err)
+	}
+	if auth.Client.Token() != "alice-token" {
+		t.Errorf("token should be alice-token got: %v", auth.Client.Token())
+	}
+
+	err = auth.Reauthenticate("alice-token")
+	if err != nil {
+		t.Errorf("error logging in again: %v", err)
+	}
+	if prompts != 1 {
+		t.Errorf("expected to be prompted once, got %d", prompts)
+	}
+}
@@ -0,0 +1,189 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/uswitch/vault-creds/pkg/metrics"
+)
+
+func TestTokenExpiring(t *testing.T) {
+	increment := time.Hour
+
+	if tokenExpiring(&api.SecretAuth{LeaseDuration: 3600, Renewable: true}, increment, time.Hour) {
+		t.Errorf("token granted the full increment should not be expiring")
+	}
+	if tokenExpiring(&api.SecretAuth{LeaseDuration: 600, Renewable: true}, increment, 10*time.Minute) {
+		t.Errorf("token with a 10m role ttl should not be expiring")
+	}
+	if !tokenExpiring(&api.SecretAuth{LeaseDuration: 600, Renewable: true}, increment, time.Hour) {
+		t.Errorf("token granted less than before should be expiring")
+	}
+	if !tokenExpiring(&api.SecretAuth{LeaseDuration: 3600, Renewable: false}, increment, time.Hour) {
+		t.Errorf("non-renewable token should be expiring")
+	}
+	if tokenExpiring(&api.SecretAuth{LeaseDuration: 0}, increment, time.Hour) {
+		t.Errorf("token without ttl should not be expiring")
+	}
+}
+
+func tokenServer(tokenType string, period int, increments *[]int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth/token/lookup-self":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"data": map[string]interface{}{"ttl": 600, "type": tokenType, "period": period, "renewable": tokenType != "batch"},
+			})
+		case "/v1/auth/token/renew-self":
+			var body struct {
+				Increment int `json:"increment"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			*increments = append(*increments, body.Increment)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"auth": map[string]interface{}{"client_token": r.Header.Get("X-Vault-Token"), "lease_duration": 600, "renewable": true},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestBatchTokenLogsInAgain(t *testing.T) {
+	var increments []int
+	server := tokenServer("batch", 0, &increments)
+	defer server.Close()
+
+	client, err := createUnauthenticatedClient(&VaultConfig{VaultAddr: server.URL, TLS: &TLSConfig{}})
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+	client.SetToken("batch")
+	login := func(c *api.Client) (*api.Secret, error) {
+		c.SetToken("fresh")
+		return &api.Secret{Auth: &api.SecretAuth{ClientToken: "fresh", LeaseDuration: 600}}, nil
+	}
+	auth := &AuthClient{Client: client, login: login}
+
+	err = auth.LookupSelf()
+	if err != nil {
+		t.Fatalf("error looking up token: %v", err)
+	}
+	if !auth.Batch() {
+		t.Fatalf("expected token to be a batch token")
+	}
+
+	manager := NewTokenManager(auth, metrics.NewPushGateway(""), TokenManagerConfig{Increment: time.Hour})
+	err = manager.renewAuth()
+	if err != nil {
+		t.Errorf("error renewing token: %v", err)
+	}
+	if len(increments) != 0 {
+		t.Errorf("batch token should not be renewed")
+	}
+	if client.Token() != "fresh" || auth.Logins() != 1 {
+		t.Errorf("expected to log in again, token is %s", client.Token())
+	}
+}
+
+func TestExpiredTokenLookupDenied(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"errors": ["permission denied"]}`))
+	}))
+	defer server.Close()
+
+	client, err := createUnauthenticatedClient(&VaultConfig{VaultAddr: server.URL, TLS: &TLSConfig{}})
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+	client.SetToken("expired")
+	auth := &AuthClient{Client: client}
+
+	err = auth.LookupSelf()
+	if err != ErrPermissionDenied {
+		t.Errorf("error should be permission denied got: %v", err)
+	}
+}
+
+func TestPeriodicTokenRenewedByPeriod(t *testing.T) {
+	var increments []int
+	server := tokenServer("service", 600, &increments)
+	defer server.Close()
+
+	client, err := createUnauthenticatedClient(&VaultConfig{VaultAddr: server.URL, TLS: &TLSConfig{}})
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+	client.SetToken("periodic")
+	auth := &AuthClient{Client: client, secret: &api.Secret{Auth: &api.SecretAuth{ClientToken: "periodic", LeaseDuration: 600}}}
+
+	err = auth.LookupSelf()
+	if err != nil {
+		t.Fatalf("error looking up token: %v", err)
+	}
+	if auth.Period() != 10*time.Minute {
+		t.Fatalf("expected a 10m period, got %s", auth.Period())
+	}
+
+	manager := NewTokenManager(auth, metrics.NewPushGateway(""), TokenManagerConfig{Increment: time.Hour})
+	err = manager.renewAuth()
+	if err != nil {
+		t.Errorf("error renewing token: %v", err)
+	}
+	if len(increments) != 1 || increments[0] != 600 {
+		t.Errorf("expected token to be renewed by its period, got %v", increments)
+	}
+}
+
+func TestTokenManagerPushesAlongsideSecrets(t *testing.T) {
+	var pushes int32
+	gatewayServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&pushes, 1)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer gatewayServer.Close()
+
+	sink := filepath.Join(t.TempDir(), "token")
+	err := ioutil.WriteFile(sink, []byte("agent"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	interval := sinkPollInterval
+	sinkPollInterval = 5 * time.Millisecond
+	defer func() { sinkPollInterval = interval }()
+
+	client, err := createUnauthenticatedClient(&VaultConfig{TLS: &TLSConfig{}})
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+	client.SetToken("agent")
+	auth := &AuthClient{Client: client, sink: sink, external: true}
+
+	// the token and secret managers push from their own goroutines
+	// as they do in main
+	gateway := metrics.NewPushGateway(gatewayServer.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	NewTokenManager(auth, gateway.Copy(), TokenManagerConfig{}).Run(ctx, make(chan int))
+
+	secretGateway := gateway.WithSecret("db")
+	for i := 0; i < 20; i++ {
+		secretGateway.SetSuccessTime()
+		secretGateway.Push()
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+
+	if atomic.LoadInt32(&pushes) <= 20 {
+		t.Errorf("expected the token manager to push too, got %d pushes", pushes)
+	}
+}
@@ -3,22 +3,30 @@ package vault
 import (
 	"context"
 	"errors"
-	"fmt"
-	"strings"
+	"net/http"
 	"time"
 
 	"github.com/cenkalti/backoff"
 	api "github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/sdk/helper/consts"
 	log "github.com/sirupsen/logrus"
 )
 
 const (
 	CredentialType  SecretType = "credential"
 	CertificateType SecretType = "certificate"
+	DynamicType     SecretType = "dynamic"
+	KVType          SecretType = "kv"
 )
 
 type SecretType string
 
+// IsLeased returns whether secrets of this type hold a lease
+// that should be renewed rather than requested again
+func (t SecretType) IsLeased() bool {
+	return t == CredentialType || t == DynamicType
+}
+
 var ErrPermissionDenied = errors.New("permission denied")
 var ErrLeaseNotFound = errors.New("lease not found or is not renewable")
 
@@ -27,13 +35,20 @@ type Secret interface {
 	EnvVars() map[string]string
 }
 
+// LeasedSecret is a secret backed by a renewable Vault lease
+type LeasedSecret interface {
+	Secret
+	Lease() *api.Secret
+	ExpireTime() (time.Time, error)
+	SetExpireTime(expire time.Time)
+}
+
 type SecretsProvider interface {
 	Fetch() (Secret, error)
 }
 
 type CredentialsRenewer interface {
 	Renew(ctx context.Context) error
-	RevokeSelf(ctx context.Context)
 	Run(ctx context.Context, c chan int)
 	Save() error
 }
@@ -56,27 +71,143 @@ type Credentials struct {
 	LeaseExpireTime *string
 }
 
+// DynamicSecret is a leased secret from any dynamic secrets
+// engine, all of the returned data is made available to templates
+type DynamicSecret struct {
+	Data            map[string]interface{}
+	Secret          *api.Secret
+	LeaseExpireTime *string
+}
+
+// KVSecret is a static secret read from a kv v1 or v2 engine,
+// Version is always 0 for kv v1
+type KVSecret struct {
+	Data    map[string]interface{}
+	Version int
+	Secret  *api.Secret
+}
+
 type Certificate struct {
 	Certificate string
-	PrivateKey  string
-	Expiration  int64
-	Secret      *api.Secret
+	// PrivateKey is only written to the output and never saved in
+	// the lease, whether it was issued by Vault or generated for a
+	// CSR. Certificates aren't restored from their lease so the key
+	// isn't needed there.
+	PrivateKey string `yaml:"-"`
+	IssuingCA  string
+	CAChain    []string
+	Expiration int64
+	Secret     *api.Secret
 }
 
+// TLSConfig configures the connection to Vault. The CA and client
+// certificate files are reloaded when they change on disk.
 type TLSConfig struct {
-	CACert string
-	CAPath string
+	CACert     string
+	CAPath     string
+	ClientCert string
+	ClientKey  string
+	ServerName string
+	// MinVersion is one of tls10, tls11, tls12 or tls13
+	MinVersion string
 }
 
 type VaultConfig struct {
 	VaultAddr string
-	TLS       *TLSConfig
+	// FailoverAddrs are other endpoints of the same cluster used
+	// when VaultAddr can't be reached
+	FailoverAddrs []string
+	TLS           *TLSConfig
+	// Namespace is the Vault Enterprise namespace secrets are read
+	// from, AuthNamespace is used to log in if it differs
+	Namespace     string
+	AuthNamespace string
+}
+
+// TokenSource supplies a fresh service account token for
+// each login, in place of reading TokenFile
+type TokenSource interface {
+	Token() (string, error)
 }
 
 type KubernetesAuthConfig struct {
-	TokenFile string
+	TokenFile   string
+	TokenSource TokenSource
+	LoginPath   string
+	Role        string
+}
+
+type JWTAuthConfig struct {
+	LoginPath string
+	Role      string
+	JWT       string
+	JWTFile   string
+}
+
+type CertAuthConfig struct {
+	LoginPath string
+	Role      string
+}
+
+// AWSAuthConfig configures the aws auth method. Region selects the
+// regional STS endpoint, the global endpoint is used if it's empty.
+// ServerID is sent as X-Vault-AWS-IAM-Server-ID if the auth method
+// requires it.
+type AWSAuthConfig struct {
 	LoginPath string
 	Role      string
+	Region    string
+	ServerID  string
+}
+
+// GCPAuthConfig configures the gcp auth method. ServiceAccount
+// defaults to the instance's default service account.
+type GCPAuthConfig struct {
+	LoginPath      string
+	Role           string
+	ServiceAccount string
+	MetadataAddr   string
+}
+
+// AzureAuthConfig configures the azure auth method. ClientID
+// selects a user assigned managed identity and Resource must
+// match the resource the auth method is configured with.
+type AzureAuthConfig struct {
+	LoginPath    string
+	Role         string
+	Resource     string
+	ClientID     string
+	MetadataAddr string
+}
+
+// AgentAuthConfig configures using a token from a Vault Agent
+// auto-auth file sink
+type AgentAuthConfig struct {
+	SinkFile string
+}
+
+// TokenAuthConfig configures using an existing token, read from
+// Token or TokenFile. If Username is set a password is requested
+// from PasswordPrompt instead and used to log in at LoginPath,
+// e.g. userpass/login or ldap/login.
+type TokenAuthConfig struct {
+	Token          string
+	TokenFile      string
+	LoginPath      string
+	Username       string
+	PasswordPrompt func() (string, error)
+}
+
+type AppRoleAuthConfig struct {
+	LoginPath       string
+	RoleID          string
+	RoleIDFile      string
+	SecretID        string
+	SecretIDFile    string
+	SecretIDWrapped bool
+	// UnwrappedSecretIDFile keeps the unwrapped secret ID so that a
+	// process restoring a saved token can log in again
+	UnwrappedSecretIDFile string
 }
 
 type login struct {
@@ -102,13 +233,21 @@ func secretFields(secret *api.Secret) log.Fields {
 	return fields
 }
 
-func checkFatalError(err error) error {
-	errorString := fmt.Sprintf("%s", err)
-	if strings.Contains(errorString, "Code: 403") {
-		return ErrPermissionDenied
+// newNamespacedRequest creates a request sent to namespace rather
+// than the namespace configured on the client, if one is given
+func newNamespacedRequest(client *api.Client, namespace, method, path string) *api.Request {
+	req := client.NewRequest(method, path)
+	if namespace == "" {
+		return req
 	}
-	if strings.Contains(errorString, "lease not found or lease is not renewable") {
-		return ErrLeaseNotFound
+
+	// the client's headers are shared with every request
+	headers := make(http.Header)
+	for k, v := range req.Headers {
+		headers[k] = v
 	}
-	return nil
+	headers.Set(consts.NamespaceHeaderName, namespace)
+	req.Headers = headers
+
+	return req
 }
@@ -1,23 +1,96 @@
 package vault
 
 import (
-	"fmt"
 	"testing"
+	"time"
 )
 
-func TestFatalError(t *testing.T) {
-	err := checkFatalError(fmt.Errorf("Code: 403"))
-	if err != ErrPermissionDenied {
-		t.Errorf("error should be permission denied got: %v", err)
+func TestLeaseExpiring(t *testing.T) {
+	increment := time.Hour
+
+	if leaseExpiring(time.Hour, increment, time.Hour) {
+		t.Errorf("lease granted the full increment should not be expiring")
+	}
+	if leaseExpiring(10*time.Minute, increment, 10*time.Minute) {
+		t.Errorf("lease granted the same ttl as before should not be expiring")
+	}
+	if !leaseExpiring(40*time.Minute, increment, time.Hour) {
+		t.Errorf("lease granted less than before should be expiring")
+	}
+}
+
+func TestNextRenewal(t *testing.T) {
+	max := 15 * time.Minute
+
+	if next := nextRenewal(6*time.Minute, 0.5, 0, max); next != 3*time.Minute {
+		t.Errorf("next renewal should be 3m got: %v", next)
 	}
+	if next := nextRenewal(time.Hour, 0.5, 0, max); next != max {
+		t.Errorf("next renewal should be capped at 15m got: %v", next)
+	}
+	if next := nextRenewal(0, 0.5, 0, max); next != max {
+		t.Errorf("next renewal without ttl should be 15m got: %v", next)
+	}
+	if next := nextRenewal(time.Second, 0.5, 0, max); next != minRenewInterval {
+		t.Errorf("next renewal should be at least %v got: %v", minRenewInterval, next)
+	}
+
+	for i := 0; i < 100; i++ {
+		next := nextRenewal(10*time.Minute, 0.5, 0.1, max)
+		if next < 4*time.Minute+30*time.Second || next > 5*time.Minute+30*time.Second {
+			t.Errorf("next renewal should be within 10%% of 5m got: %v", next)
+		}
 
-	err = checkFatalError(fmt.Errorf("lease not found or lease is not renewable"))
-	if err != ErrLeaseNotFound {
-		t.Errorf("error should be lease not found got: %v", err)
+		next = nextRenewal(time.Hour, 0.5, 0.1, max)
+		if next < 13*time.Minute+30*time.Second || next > max {
+			t.Errorf("next renewal should be within 10%% below 15m got: %v", next)
+		}
 	}
+}
+
+func TestCertificateRenewal(t *testing.T) {
+	now := time.Now()
+	notBefore := now.Add(-2 * time.Hour)
+	notAfter := notBefore.Add(6 * time.Hour)
 
-	err = checkFatalError(fmt.Errorf("foo"))
+	if next := certificateRenewal(notBefore, notAfter, now, 2.0/3, 0); next != 2*time.Hour {
+		t.Errorf("next renewal should be 2/3 through the lifetime in 2h got: %v", next)
+	}
+	if next := certificateRenewal(time.Time{}, now.Add(3*time.Hour), now, 2.0/3, 0); next != 2*time.Hour {
+		t.Errorf("next renewal without a start should be 2/3 of the remaining 3h got: %v", next)
+	}
+	if next := certificateRenewal(notBefore, notAfter, notBefore.Add(5*time.Hour), 2.0/3, 0); next != minRenewInterval {
+		t.Errorf("renewal past the window should happen in %v got: %v", minRenewInterval, next)
+	}
+	if next := certificateRenewal(notBefore, notAfter, notAfter.Add(time.Hour), 2.0/3, 0); next != minRenewInterval {
+		t.Errorf("expired certificate should be renewed in %v got: %v", minRenewInterval, next)
+	}
+
+	for i := 0; i < 100; i++ {
+		next := certificateRenewal(notBefore, notAfter, now, 0.5, 0.1)
+		if next < 42*time.Minute || next > 78*time.Minute {
+			t.Errorf("next renewal should be 1h within 10%% of the 3h offset got: %v", next)
+		}
+	}
+}
+
+func TestNamespacedRequest(t *testing.T) {
+	client, err := createUnauthenticatedClient(&VaultConfig{TLS: &TLSConfig{}, Namespace: "team"})
 	if err != nil {
-		t.Errorf("error should be nil got: %v", err)
+		t.Fatalf("error creating client: %v", err)
+	}
+
+	req := newNamespacedRequest(client, "root-auth", "POST", "/v1/auth/kubernetes/login")
+	if namespace := req.Headers.Get("X-Vault-Namespace"); namespace != "root-auth" {
+		t.Errorf("request namespace should be root-auth got: %v", namespace)
+	}
+
+	if namespace := client.Headers().Get("X-Vault-Namespace"); namespace != "team" {
+		t.Errorf("client namespace should still be team got: %v", namespace)
+	}
+
+	req = newNamespacedRequest(client, "", "GET", "/v1/database/creds/foo")
+	if namespace := req.Headers.Get("X-Vault-Namespace"); namespace != "team" {
+		t.Errorf("request namespace should default to team got: %v", namespace)
 	}
 }